@@ -0,0 +1,281 @@
+//@author: hdsfade
+//@date: 2021-02-23-15:40
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var vehicleassignmentIndexName = "vehicle~assignment"
+
+//VehicleAssignment describes a train the vehicle runs from FromDate to ToDate inclusive
+type VehicleAssignment struct { //车辆排班
+	VehicleNumber  int    `json:"vehicleNumber"`
+	TrainNumber    string `json:"trainNumber"`
+	ScheduleNumber int    `json:"scheduleNumber"`
+	FromDate       string `json:"fromDate"`
+	ToDate         string `json:"toDate"`
+}
+
+//VehicleCalendar describes the trains and maintenance windows of a vehicle in date order
+type VehicleCalendar struct {
+	VehicleNumber      int                 `json:"vehicleNumber"`
+	Assignments        []VehicleAssignment `json:"assignments"`
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows"`
+}
+
+//VehicleCalendarQueryResult structure used for handing result of query vehicle calendar
+type VehicleCalendarQueryResult struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data VehicleCalendar `json:"data"`
+}
+
+//vehicleAssignments returns all assignments of the vehicle ordered by FromDate
+func vehicleAssignments(ctx contractapi.TransactionContextInterface, vehicleNumber int) ([]VehicleAssignment, error) {
+	assignmentResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(vehicleassignmentIndexName, []string{strconv.Itoa(vehicleNumber)})
+	if err != nil {
+		return nil, err
+	}
+	defer assignmentResultsIterator.Close()
+
+	assignments := []VehicleAssignment{}
+	for assignmentResultsIterator.HasNext() {
+		assignmentQueryResponse, err := assignmentResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var assignment VehicleAssignment
+		err = json.Unmarshal(assignmentQueryResponse.Value, &assignment)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, nil
+}
+
+//putVehicleAssignment writes the assignment to the world state
+func putVehicleAssignment(ctx contractapi.TransactionContextInterface, assignment *VehicleAssignment) error {
+	assignmentIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleassignmentIndexName,
+		[]string{strconv.Itoa(assignment.VehicleNumber), assignment.FromDate, assignment.TrainNumber})
+	if err != nil {
+		return err
+	}
+	assignmentJSON, err := json.Marshal(assignment)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(assignmentIndexKey, assignmentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//delVehicleAssignment deletes the assignment from the world state
+func delVehicleAssignment(ctx contractapi.TransactionContextInterface, assignment *VehicleAssignment) error {
+	assignmentIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleassignmentIndexName,
+		[]string{strconv.Itoa(assignment.VehicleNumber), assignment.FromDate, assignment.TrainNumber})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelState(assignmentIndexKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete from world state. %v", err)
+	}
+	return nil
+}
+
+//checkVehicleUnassigned judges the vehicle if runs no train other than exceptTrain from the date from to the date to or not.
+//The assignments are read by a range query, so a concurrent transaction assigning the vehicle is detected as a phantom read
+//and only one of them is committed.
+func checkVehicleUnassigned(ctx contractapi.TransactionContextInterface, vehicleNumber int, from, to time.Time, exceptTrain string) error {
+	assignments, err := vehicleAssignments(ctx, vehicleNumber)
+	if err != nil {
+		return err
+	}
+	fromDate, toDate := from.Format(dateLayout), to.Format(dateLayout)
+	for _, assignment := range assignments {
+		if assignment.TrainNumber == exceptTrain {
+			continue
+		}
+		if assignment.FromDate <= toDate && fromDate <= assignment.ToDate {
+			return fmt.Errorf("the vehicle %d runs the train %s from %s to %s",
+				vehicleNumber, assignment.TrainNumber, assignment.FromDate, assignment.ToDate)
+		}
+	}
+	return nil
+}
+
+//assignVehicle assigns the vehicle to the train from its departure date to arrivalDate,
+//the vehicle must be available and run no other train in the meantime
+func assignVehicle(ctx contractapi.TransactionContextInterface, vehicleNumber int, trainNumber, arrivalDate string) error {
+	scheduleNumber, err := trainScheduleNumber(trainNumber)
+	if err != nil {
+		return err
+	}
+	departure, err := trainDepartureDate(trainNumber)
+	if err != nil {
+		return err
+	}
+	from, to, err := parseDateRange(departure.Format(dateLayout), arrivalDate)
+	if err != nil {
+		return err
+	}
+	err = checkVehicleAvailable(ctx, vehicleNumber, from, to)
+	if err != nil {
+		return err
+	}
+	err = checkVehicleUnassigned(ctx, vehicleNumber, from, to, trainNumber)
+	if err != nil {
+		return err
+	}
+
+	assignment := VehicleAssignment{
+		VehicleNumber:  vehicleNumber,
+		TrainNumber:    trainNumber,
+		ScheduleNumber: scheduleNumber,
+		FromDate:       from.Format(dateLayout),
+		ToDate:         to.Format(dateLayout),
+	}
+	return putVehicleAssignment(ctx, &assignment)
+}
+
+//UpdateScheduleVehicle changes the vehicle of the schedule, trains of the schedule that haven't left their starting station
+//are moved to the new vehicle, which must be available and run no other train at the same time
+func (s *SmartContract) UpdateScheduleVehicle(ctx contractapi.TransactionContextInterface, scheduleNumber, vehicleNumber int) (Result, error) {
+	schedule, err := getSchedule(ctx, scheduleNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if schedule.VehicleNumber == vehicleNumber {
+		return failure(fmt.Errorf("the schedule %d already uses the vehicle %d", scheduleNumber, vehicleNumber))
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	err = checkVehicleAvailable(ctx, vehicleNumber, txTime, txTime)
+	if err != nil {
+		return failure(err)
+	}
+
+	assignments, err := vehicleAssignments(ctx, schedule.VehicleNumber)
+	if err != nil {
+		return failure(err)
+	}
+	today := txTime.Format(dateLayout)
+	for _, assignment := range assignments {
+		if assignment.ScheduleNumber != scheduleNumber || assignment.ToDate < today {
+			continue
+		}
+		//a train that has left its starting station keeps its vehicle until it arrives
+		exists, err := s.WayBillExists(ctx, assignment.TrainNumber)
+		if err != nil {
+			return failure(err)
+		}
+		if exists {
+			waybill, err := getWayBill(ctx, assignment.TrainNumber)
+			if err != nil {
+				return failure(err)
+			}
+			if len(waybill.LeaveTime) > 0 {
+				continue
+			}
+		}
+		train, err := getTrain(ctx, assignment.TrainNumber)
+		if err != nil {
+			return failure(err)
+		}
+		err = assignVehicle(ctx, vehicleNumber, train.TrainNumber, train.ArrivalDate)
+		if err != nil {
+			return failure(err)
+		}
+		err = delVehicleAssignment(ctx, &assignment)
+		if err != nil {
+			return failure(err)
+		}
+		train.VehicleNumber = vehicleNumber
+		err = putTrain(ctx, train)
+		if err != nil {
+			return failure(err)
+		}
+	}
+
+	//move compositekey vehicle~schedule
+	value := []byte{0x00}
+	vehiclescheduleIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		vehiclescheduleIndexName, []string{strconv.Itoa(schedule.VehicleNumber), strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return failure(err)
+	}
+	err = ctx.GetStub().DelState(vehiclescheduleIndexKey)
+	if err != nil {
+		return failure(err)
+	}
+	vehiclescheduleIndexKey, err = ctx.GetStub().CreateCompositeKey(
+		vehiclescheduleIndexName, []string{strconv.Itoa(vehicleNumber), strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return failure(err)
+	}
+	err = ctx.GetStub().PutState(vehiclescheduleIndexKey, value)
+	if err != nil {
+		return failure(err)
+	}
+
+	schedule.VehicleNumber = vehicleNumber
+	err = putSchedule(ctx, schedule)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryVehicleCalendar returns the trains and maintenance windows of the vehicle in date order
+func (s *SmartContract) QueryVehicleCalendar(ctx contractapi.TransactionContextInterface, vehicleNumber int) VehicleCalendarQueryResult {
+	emptyCalendar := VehicleCalendar{
+		VehicleNumber:      vehicleNumber,
+		Assignments:        []VehicleAssignment{},
+		MaintenanceWindows: []MaintenanceWindow{},
+	}
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return VehicleCalendarQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: emptyCalendar,
+		}
+	}
+	assignments, err := vehicleAssignments(ctx, vehicleNumber)
+	if err != nil {
+		return VehicleCalendarQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: emptyCalendar,
+		}
+	}
+
+	windows := []MaintenanceWindow{}
+	windows = append(windows, vehicle.MaintenanceWindows...)
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].FromDate < windows[j].FromDate
+	})
+	return VehicleCalendarQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: VehicleCalendar{
+			VehicleNumber:      vehicleNumber,
+			Assignments:        assignments,
+			MaintenanceWindows: windows,
+		},
+	}
+}
@@ -4,11 +4,22 @@ package chaincode
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"time"
 )
 
 var cargoIndexName = "cargo"
+var cargorevisionIndexName = "cargo~revision"
+
+//cargo states
+const (
+	CargoStateDraft     = "draft"     //草稿
+	CargoStateSealed    = "sealed"    //已封装
+	CargoStateInTransit = "intransit" //运输中
+	CargoStateDelivered = "delivered" //已送达
+)
 
 type Cargo struct { //货物清单
 	TrainNumber        string   `json:"trainNumber"`
@@ -16,9 +27,36 @@ type Cargo struct { //货物清单
 	CargoType          []string `json:"cargoType"`
 	GoodsNum           []int    `json:"goodsNum"`
 	GoodsName          []string `json:"goodsName"`
-	GoodsOrderId       []int    `json"goodsOrderId"`
+	GoodsOrderId       []int    `json:"goodsOrderId"`
+	UNNumber           []string `json:"unNumber,omitempty" metadata:",optional"`    //UN numbers of dangerous goods in the order of GoodsName
+	HazardClass        []string `json:"hazardClass,omitempty" metadata:",optional"` //ADR/RID classes, empty for goods which are not dangerous
 	StationCheckResult []bool   `json:"stationCheckResult"`
 	CheckDescription   []string `json:"checkDescription"`
+	Version            int      `json:"version"`
+	State              string   `json:"state"`
+	//goods of multi-leg orders taken over from or handed over to other trains
+	Transfers []CargoTransfer `json:"transfers,omitempty" metadata:",optional"`
+}
+
+//CargoTransfer describes goods of a leg of a multi-leg order handed over from a train to another at a station
+type CargoTransfer struct { //中转
+	OrderId         int    `json:"orderId"`
+	HandoverStation string `json:"handoverStation"`
+	FromTrainNumber string `json:"fromTrainNumber"`
+	ToTrainNumber   string `json:"toTrainNumber"`
+}
+
+//CargoRevision describes orders changed by a version of a cargo
+type CargoRevision struct {
+	TrainNumber     string `json:"trainNumber"`
+	Version         int    `json:"version"`
+	AddedOrderIds   []int  `json:"addedOrderIds"`
+	RemovedOrderIds []int  `json:"removedOrderIds"`
+	RevisionTime    string `json:"revisionTime"`
+}
+
+type CargoRevisions struct {
+	RevisionsData []CargoRevision `json:"revisions"`
 }
 
 //CargoQueryResult structure used for handing result of query
@@ -28,6 +66,13 @@ type CargoQueryResult struct {
 	Data Cargo  `json:"data"`
 }
 
+//CargoRevisionQueryResults structure used for handing result of query revisions
+type CargoRevisionQueryResults struct {
+	Code int            `json:"code"`
+	Msg  string         `json:"msg"`
+	Data CargoRevisions `json:"data"`
+}
+
 //CargoExists judges a order if exists or not
 func (s *SmartContract) CargoExists(ctx contractapi.TransactionContextInterface, trainNumber string) (bool, error) {
 	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{trainNumber})
@@ -42,16 +87,251 @@ func (s *SmartContract) CargoExists(ctx contractapi.TransactionContextInterface,
 	return cargoJSON != nil, nil
 }
 
-//CreateCargo issues a new cargo to the world state with orders.
-func (s *SmartContract) CreateCargo(ctx contractapi.TransactionContextInterface, trainNumber string) Result {
+//buildCargo collects the checked orders of the train trainNumber into a cargo
+func buildCargo(ctx contractapi.TransactionContextInterface, trainNumber string) (*Cargo, error) {
+	cargo := Cargo{
+		TrainNumber:        trainNumber,
+		TotalTypeNum:       0,
+		CargoType:          []string{},
+		GoodsNum:           []int{},
+		GoodsName:          []string{},
+		GoodsOrderId:       []int{},
+		StationCheckResult: []bool{},
+		CheckDescription:   []string{},
+		Version:            0,
+		State:              CargoStateDraft,
+	}
+
+	//iterate all orders
+	orderIds, err := trainOrderIds(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, orderId := range orderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		if order.CheckResult && order.State != OrderStateOffloaded {
+			err = appendCargoOrder(ctx, &cargo, order)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &cargo, nil
+}
+
+//appendCargoOrder appends the goods of the order to the cargo
+func appendCargoOrder(ctx contractapi.TransactionContextInterface, cargo *Cargo, order *Order) error {
+	cargo.TotalTypeNum += order.TotalTypeNum
+	cargo.CargoType = append(cargo.CargoType, order.CargoType...)
+	cargo.GoodsNum = append(cargo.GoodsNum, order.GoodsNum...)
+	cargo.GoodsName = append(cargo.GoodsName, order.GoodsName...)
+	cargo.GoodsOrderId = append(cargo.GoodsOrderId, order.OrderId)
+	if len(order.HazardClass) == len(order.GoodsName) {
+		cargo.UNNumber = append(cargo.UNNumber, order.UNNumber...)
+		cargo.HazardClass = append(cargo.HazardClass, order.HazardClass...)
+	} else {
+		cargo.UNNumber = append(cargo.UNNumber, make([]string, len(order.GoodsName))...)
+		cargo.HazardClass = append(cargo.HazardClass, make([]string, len(order.GoodsName))...)
+	}
+
+	transfers, err := orderTransfers(ctx, order)
+	if err != nil {
+		return err
+	}
+	cargo.Transfers = append(cargo.Transfers, transfers...)
+	return nil
+}
+
+//removeCargoOrder removes the goods of the order from the draft cargo of its train as a new version,
+//nothing is written if the train has no cargo or its cargo doesn't list the order
+func removeCargoOrder(ctx contractapi.TransactionContextInterface, order *Order) error {
+	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{order.TrainNumber})
+	if err != nil {
+		return err
+	}
+	cargoJSON, err := ctx.GetStub().GetState(cargoIndexKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state %v", err)
+	}
+	if cargoJSON == nil {
+		return nil
+	}
+	cargo, err := getCargo(ctx, order.TrainNumber)
+	if err != nil {
+		return err
+	}
+	if !containsInt(cargo.GoodsOrderId, order.OrderId) {
+		return nil
+	}
+	if cargo.State != CargoStateDraft {
+		return fmt.Errorf("the cargo %s is %s and lists the order %d", order.TrainNumber, cargo.State, order.OrderId)
+	}
+
+	//the other orders are listed again in the same order
+	rebuilt := Cargo{
+		TrainNumber:        cargo.TrainNumber,
+		TotalTypeNum:       0,
+		CargoType:          []string{},
+		GoodsNum:           []int{},
+		GoodsName:          []string{},
+		GoodsOrderId:       []int{},
+		StationCheckResult: cargo.StationCheckResult,
+		CheckDescription:   cargo.CheckDescription,
+		Version:            cargo.Version + 1,
+		State:              cargo.State,
+	}
+	for _, orderId := range cargo.GoodsOrderId {
+		if orderId == order.OrderId {
+			continue
+		}
+		listed, err := getOrder(ctx, orderId)
+		if err != nil {
+			return err
+		}
+		err = appendCargoOrder(ctx, &rebuilt, listed)
+		if err != nil {
+			return err
+		}
+	}
+	err = putCargo(ctx, &rebuilt)
+	if err != nil {
+		return err
+	}
+	return putCargoRevision(ctx, order.TrainNumber, rebuilt.Version, []int{}, []int{order.OrderId})
+}
+
+//orderTransfers returns the handovers of the order from the train of its previous leg and to the train of its next leg
+func orderTransfers(ctx contractapi.TransactionContextInterface, order *Order) ([]CargoTransfer, error) {
+	var transfers []CargoTransfer
+	if order.PreviousLegOrderId != 0 {
+		previous, err := getOrder(ctx, order.PreviousLegOrderId)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, CargoTransfer{
+			OrderId:         order.OrderId,
+			HandoverStation: order.StartingStation,
+			FromTrainNumber: previous.TrainNumber,
+			ToTrainNumber:   order.TrainNumber,
+		})
+	}
+	if order.NextLegOrderId != 0 {
+		next, err := getOrder(ctx, order.NextLegOrderId)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, CargoTransfer{
+			OrderId:         order.OrderId,
+			HandoverStation: order.DestinationStation,
+			FromTrainNumber: order.TrainNumber,
+			ToTrainNumber:   next.TrainNumber,
+		})
+	}
+	return transfers, nil
+}
+
+//getCargo reads the cargo of the train trainNumber from the world state
+func getCargo(ctx contractapi.TransactionContextInterface, trainNumber string) (*Cargo, error) {
 	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{trainNumber})
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+		return nil, err
+	}
+	cargoJSON, err := ctx.GetStub().GetState(cargoIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if cargoJSON == nil {
+		return nil, fmt.Errorf("the cargo %s does not exist", trainNumber)
+	}
+
+	var cargo Cargo
+	err = json.Unmarshal(cargoJSON, &cargo)
+	if err != nil {
+		return nil, err
+	}
+	if cargo.State == "" {
+		cargo.State = CargoStateDraft
+	}
+	return &cargo, nil
+}
+
+//putCargo writes the cargo to the world state
+func putCargo(ctx contractapi.TransactionContextInterface, cargo *Cargo) error {
+	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{cargo.TrainNumber})
+	if err != nil {
+		return err
+	}
+	cargoJSON, err := json.Marshal(cargo)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(cargoIndexKey, cargoJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//cargoRevisionKey returns the key of the version of the cargo,
+//version is zero padded so that revisions are iterated in order
+func cargoRevisionKey(ctx contractapi.TransactionContextInterface, trainNumber string, version int) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(cargorevisionIndexName, []string{trainNumber, fmt.Sprintf("%06d", version)})
+}
+
+//deleteCargo deletes the cargo of the train trainNumber and its versions 1..version from the world state.
+func deleteCargo(ctx contractapi.TransactionContextInterface, trainNumber string, version int) error {
+	for v := 1; v <= version; v++ {
+		cargoRevisionIndexKey, err := cargoRevisionKey(ctx, trainNumber, v)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelState(cargoRevisionIndexKey)
+		if err != nil {
+			return err
 		}
 	}
 
+	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{trainNumber})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(cargoIndexKey)
+}
+
+//putCargoRevision records the orders added to and removed from the cargo by its version
+func putCargoRevision(ctx contractapi.TransactionContextInterface, trainNumber string, version int, addedOrderIds, removedOrderIds []int) error {
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	revision := CargoRevision{
+		TrainNumber:     trainNumber,
+		Version:         version,
+		AddedOrderIds:   addedOrderIds,
+		RemovedOrderIds: removedOrderIds,
+		RevisionTime:    txTime.Format(time.RFC3339),
+	}
+	revisionJSON, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+
+	cargoRevisionIndexKey, err := cargoRevisionKey(ctx, trainNumber, version)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(cargoRevisionIndexKey, revisionJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//CreateCargo issues a new cargo to the world state with orders.
+func (s *SmartContract) CreateCargo(ctx contractapi.TransactionContextInterface, trainNumber string) Result {
 	exists, err := s.CargoExists(ctx, trainNumber)
 	if err != nil {
 		return Result{
@@ -81,79 +361,22 @@ func (s *SmartContract) CreateCargo(ctx contractapi.TransactionContextInterface,
 		}
 	}
 
-	cargo := Cargo{
-		TrainNumber:        trainNumber,
-		TotalTypeNum:       0,
-		CargoType:          []string{},
-		GoodsNum:           []int{},
-		GoodsName:          []string{},
-		GoodsOrderId:       []int{},
-		StationCheckResult: []bool{},
-		CheckDescription:   []string{},
-	}
-
-	//iterate all orders
-	orderResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(trainorderIndexName, []string{trainNumber})
+	cargo, err := buildCargo(ctx, trainNumber)
 	if err != nil {
 		return Result{
 			Code: 402,
 			Msg:  err.Error(),
 		}
 	}
-	var order Order
-	for orderResultsIterator.HasNext() {
-		orderQueryResponse, err := orderResultsIterator.Next()
-		if err != nil {
-			return Result{
-				Code: 402,
-				Msg:  err.Error(),
-			}
-		}
-		orderId := orderQueryResponse.Value
-		orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{string(orderId)})
-		if err != nil {
-			return Result{
-				Code: 402,
-				Msg:  err.Error(),
-			}
-		}
-		orderJSON, err := ctx.GetStub().GetState(orderIndexKey)
-		if err != nil {
-			return Result{
-				Code: 402,
-				Msg:  err.Error(),
-			}
-		}
-		if orderJSON == nil {
-			return Result{
-				Code: 402,
-				Msg:  fmt.Sprintf("the order %s does not exist", orderId),
-			}
-		}
-		err = json.Unmarshal(orderJSON, &order)
-		if err != nil {
-			return Result{
-				Code: 402,
-				Msg:  err.Error(),
-			}
-		}
-		if order.CheckResult {
-			cargo.TotalTypeNum += order.TotalTypeNum
-			cargo.CargoType = append(cargo.CargoType, order.CargoType...)
-			cargo.GoodsNum = append(cargo.GoodsNum, order.GoodsNum...)
-			cargo.GoodsName = append(cargo.GoodsName, order.GoodsName...)
-			cargo.GoodsOrderId = append(cargo.GoodsOrderId, order.OrderId)
-		}
-	}
-	cargoJSON, err := json.Marshal(cargo)
+	cargo.Version = 1
+	err = putCargo(ctx, cargo)
 	if err != nil {
 		return Result{
 			Code: 402,
 			Msg:  err.Error(),
 		}
 	}
-
-	err = ctx.GetStub().PutState(cargoIndexKey, cargoJSON)
+	err = putCargoRevision(ctx, trainNumber, cargo.Version, cargo.GoodsOrderId, []int{})
 	if err != nil {
 		return Result{
 			Code: 402,
@@ -167,24 +390,93 @@ func (s *SmartContract) CreateCargo(ctx contractapi.TransactionContextInterface,
 	}
 }
 
-//DeleteCargo deletes a cargo by trainNumber from the world state.
+//RebuildManifest recomputes the cargo of the train trainNumber from its current orders.
+//Only a draft or sealed cargo could be rebuilt, and not if its dangerous goods violate the segregation table.
+//A failure is returned as an error so that no write of the transaction is committed.
+func (s *SmartContract) RebuildManifest(ctx contractapi.TransactionContextInterface, trainNumber string) (Result, error) {
+	cargo, err := getCargo(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+
+	//a sealed cargo goes back to draft and has to be sealed again,
+	//the cargo couldn't be rebuilt any more once the train has left its starting station
+	if cargo.State != CargoStateDraft && cargo.State != CargoStateSealed {
+		return failure(fmt.Errorf("the cargo %s is %s, it couldn't be rebuilt after the train left its starting station", trainNumber, cargo.State))
+	}
+
+	rebuilt, err := buildCargo(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	//the segregation table may have changed since the orders were created
+	orders := []*Order{}
+	for _, orderId := range rebuilt.GoodsOrderId {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return failure(err)
+		}
+		orders = append(orders, order)
+	}
+	err = checkSegregation(ctx, orders)
+	if err != nil {
+		return failure(err)
+	}
+	addedOrderIds := []int{}
+	for _, orderId := range rebuilt.GoodsOrderId {
+		if !containsInt(cargo.GoodsOrderId, orderId) {
+			addedOrderIds = append(addedOrderIds, orderId)
+		}
+	}
+	removedOrderIds := []int{}
+	for _, orderId := range cargo.GoodsOrderId {
+		if !containsInt(rebuilt.GoodsOrderId, orderId) {
+			removedOrderIds = append(removedOrderIds, orderId)
+		}
+	}
+	if len(addedOrderIds) == 0 && len(removedOrderIds) == 0 {
+		return Result{
+			Code: 200,
+			Msg:  fmt.Sprintf("the cargo %s is up to date", trainNumber),
+		}, nil
+	}
+
+	//check results recorded by stations are kept
+	rebuilt.StationCheckResult = cargo.StationCheckResult
+	rebuilt.CheckDescription = cargo.CheckDescription
+	rebuilt.Version = cargo.Version + 1
+	err = putCargo(ctx, rebuilt)
+	if err != nil {
+		return failure(err)
+	}
+	err = putCargoRevision(ctx, trainNumber, rebuilt.Version, addedOrderIds, removedOrderIds)
+	if err != nil {
+		return failure(err)
+	}
+
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//DeleteCargo deletes a cargo by trainNumber from the world state, only a draft cargo could be deleted.
 func (s *SmartContract) DeleteCargo(ctx contractapi.TransactionContextInterface, trainNumber string) Result {
-	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{trainNumber})
-	exists, err := s.CargoExists(ctx, trainNumber)
+	cargo, err := getCargo(ctx, trainNumber)
 	if err != nil {
 		return Result{
 			Code: 402,
 			Msg:  err.Error(),
 		}
 	}
-	if !exists {
+	if cargo.State != CargoStateDraft {
 		return Result{
 			Code: 402,
-			Msg:  fmt.Sprintf("the cargo %s does not exist", trainNumber),
+			Msg:  fmt.Sprintf("the cargo %s is %s, only a draft cargo could be deleted", trainNumber, cargo.State),
 		}
 	}
 
-	err = ctx.GetStub().DelState(trainIndexKey)
+	err = deleteCargo(ctx, trainNumber, cargo.Version)
 	if err != nil {
 		return Result{
 			Code: 402,
@@ -197,6 +489,64 @@ func (s *SmartContract) DeleteCargo(ctx contractapi.TransactionContextInterface,
 	}
 }
 
+//SealCargo seals a draft cargo and loads its orders, the train couldn't leave its starting station until its cargo is sealed,
+//and a failure is returned as an error so that no order is loaded by a failed seal
+func (s *SmartContract) SealCargo(ctx contractapi.TransactionContextInterface, trainNumber string) (Result, error) {
+	cargo, err := getCargo(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if cargo.State != CargoStateDraft {
+		return failure(fmt.Errorf("the cargo %s is %s, only a draft cargo could be sealed", trainNumber, cargo.State))
+	}
+
+	//orders of the cargo are loaded, every order is checked before any is written
+	var orders []*Order
+	for _, orderId := range cargo.GoodsOrderId {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return failure(err)
+		}
+		if order.State != OrderStateBooked {
+			continue
+		}
+		err = loadOrder(order)
+		if err != nil {
+			return failure(fmt.Errorf("the cargo %s couldn't be sealed: %v", trainNumber, err))
+		}
+		orders = append(orders, order)
+	}
+	for _, order := range orders {
+		err = putOrder(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+	}
+
+	cargo.State = CargoStateSealed
+	err = putCargo(ctx, cargo)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//setCargoState moves the cargo of the train trainNumber from state from to state to
+func setCargoState(ctx contractapi.TransactionContextInterface, trainNumber string, from, to string) error {
+	cargo, err := getCargo(ctx, trainNumber)
+	if err != nil {
+		return err
+	}
+	if cargo.State != from {
+		return fmt.Errorf("the cargo %s is %s, expected %s", trainNumber, cargo.State, from)
+	}
+	cargo.State = to
+	return putCargo(ctx, cargo)
+}
+
 //UpdateCargo updates an existing cargo in the world state with provided parameters
 func (s *SmartContract) UpdateCargo(ctx contractapi.TransactionContextInterface, trainNumber string, stationCheckResult bool, checkDescription string) Result {
 	cargoIndexKey, err := ctx.GetStub().CreateCompositeKey(cargoIndexName, []string{trainNumber})
@@ -251,9 +601,59 @@ func (s *SmartContract) UpdateCargo(ctx contractapi.TransactionContextInterface,
 	}
 }
 
-// CheckCargo updates cargo's details
-func (s *SmartContract) CheckCargo(ctx contractapi.TransactionContextInterface, trainNumber string, stationCheckResult bool, checkDescription string) Result {
-	return s.UpdateCargo(ctx, trainNumber, stationCheckResult, checkDescription)
+// CheckCargo updates cargo's details, a failed check places orders holdOrderIds (or the whole train if empty) on hold.
+// The seals observedSeals are compared with the seals affixed on the train, a broken, missing or replaced seal fails
+// the check and places the orders of its container or carriage on hold.
+// A failure is returned as an error so that seals found broken, missing or replaced aren't recorded by a failed check.
+func (s *SmartContract) CheckCargo(ctx contractapi.TransactionContextInterface, trainNumber string, stationCheckResult bool,
+	checkDescription string, holdOrderIds []int, observedSeals []SealObservation) (Result, error) {
+	exists, err := s.CargoExists(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if !exists {
+		return failure(fmt.Errorf("the cargo %s does not exist", trainNumber))
+	}
+
+	discrepancies, err := s.checkSeals(ctx, trainNumber, observedSeals)
+	if err != nil {
+		return failure(err)
+	}
+	if len(discrepancies) != 0 {
+		sealOrderIds := []int{}
+		for _, discrepancy := range discrepancies {
+			if !containsInt(sealOrderIds, discrepancy.OrderId) {
+				sealOrderIds = append(sealOrderIds, discrepancy.OrderId)
+			}
+		}
+		//a failed check without holdOrderIds holds the whole train anyway
+		if stationCheckResult {
+			holdOrderIds = sealOrderIds
+		} else if len(holdOrderIds) != 0 {
+			for _, orderId := range sealOrderIds {
+				if !containsInt(holdOrderIds, orderId) {
+					holdOrderIds = append(holdOrderIds, orderId)
+				}
+			}
+		}
+		stationCheckResult = false
+		if checkDescription != "" {
+			checkDescription += "; "
+		}
+		checkDescription += describeSealDiscrepancies(discrepancies)
+	}
+
+	if !stationCheckResult {
+		err = placeHold(ctx, trainNumber, holdOrderIds, checkDescription)
+		if err != nil {
+			return failure(err)
+		}
+	}
+	result := s.UpdateCargo(ctx, trainNumber, stationCheckResult, checkDescription)
+	if result.Code != 200 {
+		return failure(errors.New(result.Msg))
+	}
+	return result, nil
 }
 
 //QueryCargoBytrainnumber returns the cargo in the world state with given trainnumber
@@ -335,3 +735,52 @@ func (s *SmartContract) QueryCargoBytrainnumber(ctx contractapi.TransactionConte
 		Data: cargo,
 	}
 }
+
+//QueryCargoRevisions returns all versions of the cargo in the world state with given trainnumber
+func (s *SmartContract) QueryCargoRevisions(ctx contractapi.TransactionContextInterface, trainNumber string) CargoRevisionQueryResults {
+	revisionResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(cargorevisionIndexName, []string{trainNumber})
+	if err != nil {
+		return CargoRevisionQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: CargoRevisions{RevisionsData: []CargoRevision{}},
+		}
+	}
+	defer revisionResultsIterator.Close()
+
+	var revisions []CargoRevision
+	for revisionResultsIterator.HasNext() {
+		revisionQueryResponse, err := revisionResultsIterator.Next()
+		if err != nil {
+			return CargoRevisionQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: CargoRevisions{RevisionsData: []CargoRevision{}},
+			}
+		}
+
+		var revision CargoRevision
+		err = json.Unmarshal(revisionQueryResponse.Value, &revision)
+		if err != nil {
+			return CargoRevisionQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: CargoRevisions{RevisionsData: []CargoRevision{}},
+			}
+		}
+		revisions = append(revisions, revision)
+	}
+	if revisions == nil {
+		return CargoRevisionQueryResults{
+			Code: 402,
+			Msg:  "No cargo revision",
+			Data: CargoRevisions{RevisionsData: []CargoRevision{}},
+		}
+	}
+
+	return CargoRevisionQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: CargoRevisions{RevisionsData: revisions},
+	}
+}
@@ -0,0 +1,266 @@
+//@author: hdsfade
+//@date: 2021-02-22-16:05
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+)
+
+var traincarriageIndexName = "train~carriage"
+
+//CarriageTypeGeneral is the type of carriages created without a type
+var CarriageTypeGeneral = "general"
+
+//carriage states
+const (
+	CarriageStatusFree     = "free"     //空闲
+	CarriageStatusReserved = "reserved" //已预留
+	CarriageStatusBooked   = "booked"   //已订
+)
+
+//Carriage describes a carriage of a train and the order or reservation it is allocated to
+type Carriage struct { //车厢
+	TrainNumber   string `json:"trainNumber"`
+	Position      int    `json:"position"` //position in the train, from 1
+	CarriageType  string `json:"carriageType"`
+	Status        string `json:"status"`
+	OrderId       int    `json:"orderId"`
+	ReservationId string `json:"reservationId"`
+}
+
+type Carriages struct {
+	CarriagesData []Carriage `json:"carriages"`
+}
+
+//CarriageQueryResults structure used for handing result of query carriages
+type CarriageQueryResults struct {
+	Code int       `json:"code"`
+	Msg  string    `json:"msg"`
+	Data Carriages `json:"data"`
+}
+
+//getCarriage reads the carriage position of the train from the world state
+func getCarriage(ctx contractapi.TransactionContextInterface, trainNumber string, position int) (*Carriage, error) {
+	carriageIndexKey, err := ctx.GetStub().CreateCompositeKey(traincarriageIndexName, []string{trainNumber, fmt.Sprintf("%04d", position)})
+	if err != nil {
+		return nil, err
+	}
+	carriageJSON, err := ctx.GetStub().GetState(carriageIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if carriageJSON == nil {
+		return nil, fmt.Errorf("the train %s has no carriage %d", trainNumber, position)
+	}
+
+	var carriage Carriage
+	err = json.Unmarshal(carriageJSON, &carriage)
+	if err != nil {
+		return nil, err
+	}
+	return &carriage, nil
+}
+
+//putCarriage writes the carriage to the world state
+func putCarriage(ctx contractapi.TransactionContextInterface, carriage *Carriage) error {
+	carriageIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		traincarriageIndexName, []string{carriage.TrainNumber, fmt.Sprintf("%04d", carriage.Position)})
+	if err != nil {
+		return err
+	}
+	carriageJSON, err := json.Marshal(carriage)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(carriageIndexKey, carriageJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//trainCarriages returns all carriages of the train in order
+func trainCarriages(ctx contractapi.TransactionContextInterface, trainNumber string) ([]Carriage, error) {
+	carriageResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(traincarriageIndexName, []string{trainNumber})
+	if err != nil {
+		return nil, err
+	}
+	defer carriageResultsIterator.Close()
+
+	carriages := []Carriage{}
+	for carriageResultsIterator.HasNext() {
+		carriageQueryResponse, err := carriageResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var carriage Carriage
+		err = json.Unmarshal(carriageQueryResponse.Value, &carriage)
+		if err != nil {
+			return nil, err
+		}
+		carriages = append(carriages, carriage)
+	}
+	return carriages, nil
+}
+
+//trainCarriageLeft computes the number of free carriages of the train
+func trainCarriageLeft(ctx contractapi.TransactionContextInterface, train *Train) (int, error) {
+	carriages, err := trainCarriages(ctx, train.TrainNumber)
+	if err != nil {
+		return 0, err
+	}
+	carriageLeft := 0
+	for _, carriage := range carriages {
+		if carriage.Status == CarriageStatusFree {
+			carriageLeft++
+		}
+	}
+	return carriageLeft, nil
+}
+
+//allocateCarriages allocates carriageNumber free carriages of carriageType (any type if empty) of the train
+//to the order orderId, or to the reservation reservationId if orderId is 0, and returns their positions.
+//The search starts from a position derived from the transaction id, so concurrent bookings mostly read and write
+//different keys, and two bookings of the same carriage conflict so that the train is never oversold.
+func allocateCarriages(ctx contractapi.TransactionContextInterface, trainNumber string, carriageNumber int, carriageType string,
+	orderId int, reservationId string) ([]int, error) {
+	train, err := getTrain(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	if carriageNumber <= 0 || carriageNumber > train.Capacity {
+		return nil, fmt.Errorf("the carriage number %d must be from 1 to the train %s's capacity %d", carriageNumber, trainNumber, train.Capacity)
+	}
+
+	txIdHash := sha256.Sum256([]byte(ctx.GetStub().GetTxID()))
+	start := int(binary.BigEndian.Uint32(txIdHash[:4]) % uint32(train.Capacity))
+	var positions []int
+	for i := 0; i < train.Capacity && len(positions) < carriageNumber; i++ {
+		carriage, err := getCarriage(ctx, trainNumber, (start+i)%train.Capacity+1)
+		if err != nil {
+			return nil, err
+		}
+		if carriage.Status != CarriageStatusFree || (carriageType != "" && carriage.CarriageType != carriageType) {
+			continue
+		}
+		carriage.OrderId = orderId
+		carriage.ReservationId = reservationId
+		carriage.Status = CarriageStatusBooked
+		if orderId == 0 {
+			carriage.Status = CarriageStatusReserved
+		}
+		err = putCarriage(ctx, carriage)
+		if err != nil {
+			return nil, err
+		}
+		positions = append(positions, carriage.Position)
+	}
+	//all carriages have been read if not enough are free
+	if len(positions) < carriageNumber {
+		return nil, fmt.Errorf("the train %s's carriageLeft of type %s is not enough: carriageLeft %d, carraigeNumber %d",
+			trainNumber, carriageType, len(positions), carriageNumber)
+	}
+	sort.Ints(positions)
+	return positions, nil
+}
+
+//bookReservedCarriages allocates carriages of the train reserved by the reservation to the order orderId
+func bookReservedCarriages(ctx contractapi.TransactionContextInterface, trainNumber string, positions []int, reservationId string, orderId int) error {
+	for _, position := range positions {
+		carriage, err := getCarriage(ctx, trainNumber, position)
+		if err != nil {
+			return err
+		}
+		if carriage.Status != CarriageStatusReserved || carriage.ReservationId != reservationId {
+			return fmt.Errorf("the carriage %d of the train %s is not reserved by %s", position, trainNumber, reservationId)
+		}
+		carriage.Status = CarriageStatusBooked
+		carriage.OrderId = orderId
+		err = putCarriage(ctx, carriage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//releaseCarriages frees carriages of the train allocated to the order orderId, or to the reservation reservationId
+//if orderId is 0, carriages allocated to others are left alone
+func releaseCarriages(ctx contractapi.TransactionContextInterface, trainNumber string, positions []int, orderId int, reservationId string) error {
+	for _, position := range positions {
+		carriage, err := getCarriage(ctx, trainNumber, position)
+		if err != nil {
+			return err
+		}
+		if carriage.Status == CarriageStatusFree || carriage.OrderId != orderId || (orderId == 0 && carriage.ReservationId != reservationId) {
+			continue
+		}
+		carriage.Status = CarriageStatusFree
+		carriage.OrderId = 0
+		carriage.ReservationId = ""
+		err = putCarriage(ctx, carriage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//QueryTrainConsist returns all carriages of the train in order with their allocation
+func (s *SmartContract) QueryTrainConsist(ctx contractapi.TransactionContextInterface, trainNumber string) CarriageQueryResults {
+	carriages, err := trainCarriages(ctx, trainNumber)
+	if err != nil {
+		return CarriageQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Carriages{CarriagesData: []Carriage{}},
+		}
+	}
+	if len(carriages) == 0 {
+		return CarriageQueryResults{
+			Code: 402,
+			Msg:  fmt.Sprintf("the train %s has no carriage", trainNumber),
+			Data: Carriages{CarriagesData: []Carriage{}},
+		}
+	}
+	return CarriageQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Carriages{CarriagesData: carriages},
+	}
+}
+
+//QueryOrderCarriages returns the carriages allocated to the order
+func (s *SmartContract) QueryOrderCarriages(ctx contractapi.TransactionContextInterface, orderId int) CarriageQueryResults {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return CarriageQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Carriages{CarriagesData: []Carriage{}},
+		}
+	}
+
+	carriages := []Carriage{}
+	for _, position := range order.Carriages {
+		carriage, err := getCarriage(ctx, order.TrainNumber, position)
+		if err != nil {
+			return CarriageQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Carriages{CarriagesData: []Carriage{}},
+			}
+		}
+		carriages = append(carriages, *carriage)
+	}
+	return CarriageQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Carriages{CarriagesData: carriages},
+	}
+}
@@ -0,0 +1,253 @@
+//@author: hdsfade
+//@date: 2021-02-22-16:05
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sync"
+	"testing"
+)
+
+//versionedValue is a value of the world state with the version it was written at
+type versionedValue struct {
+	value   []byte
+	version int
+}
+
+//mockLedger keeps the committed world state and validates transactions like a Fabric peer,
+//a transaction is invalid if any key it read has been written since
+type mockLedger struct {
+	state   map[string]versionedValue
+	version int
+}
+
+func newMockLedger() *mockLedger {
+	return &mockLedger{state: map[string]versionedValue{}}
+}
+
+//mockStub simulates a transaction against the committed world state,
+//reads are recorded with their versions and writes are kept until the transaction is committed
+type mockStub struct {
+	shim.ChaincodeStubInterface
+	ledger *mockLedger
+	txId   string
+	reads  map[string]int
+	writes map[string][]byte
+}
+
+func (ledger *mockLedger) newStub(txId string) *mockStub {
+	return &mockStub{
+		ledger: ledger,
+		txId:   txId,
+		reads:  map[string]int{},
+		writes: map[string][]byte{},
+	}
+}
+
+func (stub *mockStub) GetTxID() string {
+	return stub.txId
+}
+
+func (stub *mockStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+//GetState reads the committed world state, a transaction doesn't read its own writes
+func (stub *mockStub) GetState(key string) ([]byte, error) {
+	value, ok := stub.ledger.state[key]
+	stub.reads[key] = value.version
+	if !ok {
+		return nil, nil
+	}
+	return value.value, nil
+}
+
+func (stub *mockStub) PutState(key string, value []byte) error {
+	stub.writes[key] = value
+	return nil
+}
+
+//commit validates the read set of the transaction and applies its writes if it is valid
+func (ledger *mockLedger) commit(stub *mockStub) bool {
+	for key, version := range stub.reads {
+		if ledger.state[key].version != version {
+			return false
+		}
+	}
+	ledger.version++
+	for key, value := range stub.writes {
+		ledger.state[key] = versionedValue{value: value, version: ledger.version}
+	}
+	return true
+}
+
+func newMockContext(stub *mockStub) contractapi.TransactionContextInterface {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	return ctx
+}
+
+//createMockTrain commits a train of capacity general carriages
+func createMockTrain(t *testing.T, ledger *mockLedger, trainNumber string, capacity int) {
+	stub := ledger.newStub("create")
+	ctx := newMockContext(stub)
+	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{trainNumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainJSON, err := json.Marshal(Train{TrainNumber: trainNumber, Capacity: capacity, CarriageLeft: capacity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctx.GetStub().PutState(trainIndexKey, trainJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for position := 1; position <= capacity; position++ {
+		err = putCarriage(ctx, &Carriage{
+			TrainNumber:  trainNumber,
+			Position:     position,
+			CarriageType: CarriageTypeGeneral,
+			Status:       CarriageStatusFree,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !ledger.commit(stub) {
+		t.Fatal("failed to commit the train")
+	}
+}
+
+//TestAllocateCarriagesConcurrently books carriages of one train by many concurrent transactions
+//in every block until the train is full, the train must never be oversold.
+//Only the allocation is run: CreateOrder also reads all orders and invoices of the customer for its credit check,
+//so concurrent orders of one customer still conflict on those keys
+func TestAllocateCarriagesConcurrently(t *testing.T) {
+	trainNumber := "1-20210222"
+	capacity := 40
+	carriageNumber := 3
+	ledger := newMockLedger()
+	createMockTrain(t, ledger, trainNumber, capacity)
+
+	//positions booked by every committed order
+	booked := map[int][]int{}
+	orderId := 0
+	for block := 0; block < 20; block++ {
+		stubs := make([]*mockStub, 16)
+		positions := make([][]int, len(stubs))
+		errs := make([]error, len(stubs))
+		var wg sync.WaitGroup
+		for i := range stubs {
+			stubs[i] = ledger.newStub(fmt.Sprintf("tx-%d-%d", block, i))
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				positions[i], errs[i] = allocateCarriages(newMockContext(stubs[i]), trainNumber, carriageNumber, "", orderId+i+1, "")
+			}(i)
+		}
+		wg.Wait()
+
+		committed := 0
+		for i, stub := range stubs {
+			if errs[i] != nil || !ledger.commit(stub) {
+				continue
+			}
+			booked[orderId+i+1] = positions[i]
+			committed++
+		}
+		if block == 0 && committed < 2 {
+			t.Errorf("only %d of %d bookings of an empty train are committed", committed, len(stubs))
+		}
+		orderId += len(stubs)
+	}
+
+	//every carriage is booked by at most one committed order, and by the order the ledger says
+	owners := map[int]int{}
+	for id, orderPositions := range booked {
+		if len(orderPositions) != carriageNumber {
+			t.Errorf("the order %d has %d carriages, want %d", id, len(orderPositions), carriageNumber)
+		}
+		for _, position := range orderPositions {
+			if owner, ok := owners[position]; ok {
+				t.Errorf("the carriage %d is booked by both orders %d and %d", position, owner, id)
+			}
+			owners[position] = id
+		}
+	}
+	if len(owners) > capacity {
+		t.Errorf("%d carriages are booked on a train of capacity %d", len(owners), capacity)
+	}
+	if len(owners) < capacity-carriageNumber+1 {
+		t.Errorf("only %d carriages of %d are booked after all blocks", len(owners), capacity)
+	}
+	stub := ledger.newStub("query")
+	for position := 1; position <= capacity; position++ {
+		carriage, err := getCarriage(newMockContext(stub), trainNumber, position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if carriage.OrderId != owners[position] {
+			t.Errorf("the carriage %d is booked by the order %d in the world state, want %d", position, carriage.OrderId, owners[position])
+		}
+	}
+}
+
+//TestAllocateCarriagesDisjointReads checks that two bookings starting at distant carriages
+//read no common carriage, so they don't conflict at commit
+func TestAllocateCarriagesDisjointReads(t *testing.T) {
+	trainNumber := "1-20210222"
+	capacity := 40
+	carriageNumber := 3
+	ledger := newMockLedger()
+	createMockTrain(t, ledger, trainNumber, capacity)
+
+	//find two transactions whose first carriages are at least carriageNumber apart on an empty train
+	first := ledger.newStub("tx-0")
+	firstPositions, err := allocateCarriages(newMockContext(first), trainNumber, carriageNumber, "", 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var second *mockStub
+	for i := 1; i < 100 && second == nil; i++ {
+		stub := ledger.newStub(fmt.Sprintf("tx-%d", i))
+		positions, err := allocateCarriages(newMockContext(stub), trainNumber, carriageNumber, "", 2, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		disjoint := true
+		for _, position := range positions {
+			if containsInt(firstPositions, position) {
+				disjoint = false
+			}
+		}
+		if disjoint {
+			second = stub
+		}
+	}
+	if second == nil {
+		t.Fatal("no transaction starts away from the first one")
+	}
+
+	//only the train, which bookings never write, is read by both
+	trainIndexKey, err := shim.CreateCompositeKey(trainIndexName, []string{trainNumber})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range first.reads {
+		if _, ok := second.reads[key]; ok && key != trainIndexKey {
+			t.Errorf("both bookings read the key %q", key)
+		}
+	}
+	for key := range first.writes {
+		if key == trainIndexKey {
+			t.Errorf("a booking writes the train")
+		}
+	}
+	if !ledger.commit(first) || !ledger.commit(second) {
+		t.Error("disjoint bookings conflict at commit")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
 )
 
 // SmartContract provides functions for managing an Asset
@@ -19,6 +21,18 @@ type Result struct {
 	Msg  string `json:"msg"`
 }
 
+//failure returns the result and error of a failed transaction,
+//Fabric doesn't commit any write of a transaction that returns an error
+func failure(err error) (Result, error) {
+	return Result{
+		Code: 402,
+		Msg:  err.Error(),
+	}, err
+}
+
+//operatorMSPID is the MSP of the railway operator, which is a member of every private data collection
+var operatorMSPID = "OperatorMSP"
+
 //vehicle, station, line compositekey prefix
 var vehicleIndexName = "vehicle"
 var stationIndexName = "station"
@@ -40,7 +54,7 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 		if err != nil {
 			return err
 		}
-		vehicleIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndexName, []string{string(vehicle.VehicleNumber)})
+		vehicleIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndexName, []string{strconv.Itoa(vehicle.VehicleNumber)})
 		if err != nil {
 			return err
 		}
@@ -75,9 +89,12 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 
 	//Init lines, create compositekey line~station
 	lines := []Line{
-		{LineNumber: 1, WayStation: []string{"宁波", "杭州", "南京"}, WayStationType: []string{"始发站", "途径站", "终点站"}, Using: true},
-		{LineNumber: 2, WayStation: []string{"宁波", "杭州", "上海"}, WayStationType: []string{"始发站", "途径站", "终点站"}, Using: true},
-		{LineNumber: 3, WayStation: []string{"宁波", "嘉兴", "上海"}, WayStationType: []string{"始发站", "途径站", "终点站"}, Using: true},
+		{LineNumber: 1, WayStation: []string{"宁波", "杭州", "南京"}, WayStationType: []string{"始发站", "途径站", "终点站"},
+			Segments: []LineSegment{{"宁波", "杭州", 155, 150, 1435}, {"杭州", "南京", 250, 240, 1435}}, Using: true},
+		{LineNumber: 2, WayStation: []string{"宁波", "杭州", "上海"}, WayStationType: []string{"始发站", "途径站", "终点站"},
+			Segments: []LineSegment{{"宁波", "杭州", 155, 150, 1435}, {"杭州", "上海", 160, 150, 1435}}, Using: true},
+		{LineNumber: 3, WayStation: []string{"宁波", "嘉兴", "上海"}, WayStationType: []string{"始发站", "途径站", "终点站"},
+			Segments: []LineSegment{{"宁波", "嘉兴", 170, 165, 1435}, {"嘉兴", "上海", 85, 80, 1435}}, Using: true},
 	}
 	for _, line := range lines {
 		lineJSON, err := json.Marshal(line)
@@ -85,7 +102,7 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 			return err
 		}
 
-		lineIndexKey, err := ctx.GetStub().CreateCompositeKey(lineIndexName, []string{string(line.LineNumber)})
+		lineIndexKey, err := ctx.GetStub().CreateCompositeKey(lineIndexName, []string{strconv.Itoa(line.LineNumber)})
 		err = ctx.GetStub().PutState(lineIndexKey, lineJSON)
 		if err != nil {
 			return fmt.Errorf("failed to put to world state. %v", err)
@@ -93,7 +110,7 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 
 		value := []byte{0x00}
 		for _, stationName := range line.WayStation {
-			stationLineIndexKey, err := ctx.GetStub().CreateCompositeKey(stationlineIndexName, []string{stationName, string(line.LineNumber)})
+			stationLineIndexKey, err := ctx.GetStub().CreateCompositeKey(stationlineIndexName, []string{stationName, strconv.Itoa(line.LineNumber)})
 			if err != nil {
 				return err
 			}
@@ -105,4 +122,65 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+//containsInt judges a value if exists in values or not
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//containsString judges a value if exists in values or not
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//removeInt returns values without value
+func removeInt(values []int, value int) []int {
+	result := []int{}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+//getTxTime returns the timestamp of the transaction, which is the same on every endorser
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).UTC(), nil
+}
+
+//assertOperator judges the client if belongs to the operator or not
+func assertOperator(ctx contractapi.TransactionContextInterface) error {
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	if mspId != operatorMSPID {
+		return fmt.Errorf("the organization %s is not the operator %s", mspId, operatorMSPID)
+	}
+	return nil
+}
+
+//assertRole judges the client if has the attribute role with the value role or not
+func assertRole(ctx contractapi.TransactionContextInterface, role string) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue("role", role)
+	if err != nil {
+		return fmt.Errorf("the client doesn't have the role %s: %v", role, err)
+	}
+	return nil
+}
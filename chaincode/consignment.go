@@ -0,0 +1,428 @@
+//@author: hdsfade
+//@date: 2021-02-06-15:20
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
+)
+
+var consignmentnoteIndexName = "consignmentnote"
+
+//consignment note types
+const (
+	NoteTypeCIM  = "CIM"  //国际货约运单
+	NoteTypeSMGS = "SMGS" //国际货协运单
+)
+
+//Party describes the consignor or consignee of an order
+type Party struct {
+	Name     string `json:"name"`
+	Address  string `json:"address"`
+	Country  string `json:"country"`
+	Contact  string `json:"contact"`
+	MSPID    string `json:"mspId,omitempty" metadata:",optional"`    //organization of the party's identities
+	Identity string `json:"identity,omitempty" metadata:",optional"` //client id of the consignee's identity taking delivery
+}
+
+//CustomsDeclaration describes a customs declaration of an order
+type CustomsDeclaration struct { //报关单
+	DeclarationNumber string `json:"declarationNumber"`
+	DeclarationType   string `json:"declarationType"` //export, transit or import
+	Country           string `json:"country"`
+	CustomsOffice     string `json:"customsOffice"`
+}
+
+//ConsignmentGoods describes a kind of goods in a consignment note
+type ConsignmentGoods struct {
+	CargoType string `json:"cargoType"`
+	GoodsName string `json:"goodsName"`
+	GoodsNum  int    `json:"goodsNum"`
+}
+
+//ConsignmentNote is the document rendered from an order, its canonical json is signed by every participant
+type ConsignmentNote struct { //运单(CIM/SMGS)
+	NoteType            string               `json:"noteType"`
+	OrderId             int                  `json:"orderId"`
+	TrainNumber         string               `json:"trainNumber"`
+	IssueDate           string               `json:"issueDate"`
+	Consignor           Party                `json:"consignor"`
+	Consignee           Party                `json:"consignee"`
+	StartingStation     string               `json:"startingStation"`
+	DestinationStation  string               `json:"destinationStation"`
+	BorderPoints        []string             `json:"borderPoints"`
+	CustomsDeclarations []CustomsDeclaration `json:"customsDeclarations"`
+	CarriageNumber      int                  `json:"carriageNumber"`
+	Goods               []ConsignmentGoods   `json:"goods"`
+}
+
+//NoteSignature records that an organization signed off a consignment note
+type NoteSignature struct {
+	MSPID    string `json:"mspId"`
+	SignTime string `json:"signTime"`
+}
+
+//ConsignmentNoteRecord describes the consignment note of an order stored in the world state
+type ConsignmentNoteRecord struct {
+	OrderId    int             `json:"orderId"`
+	NoteType   string          `json:"noteType"`
+	Document   string          `json:"document"` //canonical json of ConsignmentNote
+	Hash       string          `json:"hash"`     //hex encoded sha256 of Document
+	Signatures []NoteSignature `json:"signatures"`
+}
+
+//ConsignmentNoteQueryResult structure used for handing result of query
+type ConsignmentNoteQueryResult struct {
+	Code int                   `json:"code"`
+	Msg  string                `json:"msg"`
+	Data ConsignmentNoteRecord `json:"data"`
+}
+
+//renderConsignmentNote renders the order into the canonical json of its consignment note and the hash of it.
+//Struct fields are always marshaled in the same order without spaces, so every endorser gets the same bytes.
+func renderConsignmentNote(order *Order, noteType, issueDate string) (string, string, error) {
+	note := ConsignmentNote{
+		NoteType:            noteType,
+		OrderId:             order.OrderId,
+		TrainNumber:         order.TrainNumber,
+		IssueDate:           issueDate,
+		Consignor:           order.Consignor,
+		Consignee:           order.Consignee,
+		StartingStation:     order.StartingStation,
+		DestinationStation:  order.DestinationStation,
+		BorderPoints:        []string{},
+		CustomsDeclarations: []CustomsDeclaration{},
+		CarriageNumber:      order.CarriageNumber,
+		Goods:               []ConsignmentGoods{},
+	}
+	note.BorderPoints = append(note.BorderPoints, order.BorderPoints...)
+	note.CustomsDeclarations = append(note.CustomsDeclarations, order.CustomsDeclarations...)
+	for i := range order.GoodsName {
+		goods := ConsignmentGoods{GoodsName: order.GoodsName[i]}
+		if i < len(order.CargoType) {
+			goods.CargoType = order.CargoType[i]
+		}
+		if i < len(order.GoodsNum) {
+			goods.GoodsNum = order.GoodsNum[i]
+		}
+		note.Goods = append(note.Goods, goods)
+	}
+
+	noteJSON, err := json.Marshal(note)
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.Sum256(noteJSON)
+	return string(noteJSON), hex.EncodeToString(hash[:]), nil
+}
+
+//checkParty judges a party if has all details required by a consignment note or not
+func checkParty(role string, party Party) error {
+	if party.Name == "" || party.Address == "" || party.Country == "" {
+		return fmt.Errorf("the %s's name, address and country are required", role)
+	}
+	return nil
+}
+
+//getConsignmentNote reads the consignment note of the order orderId, nil if it has not been generated
+func getConsignmentNote(ctx contractapi.TransactionContextInterface, orderId int) (*ConsignmentNoteRecord, error) {
+	noteIndexKey, err := ctx.GetStub().CreateCompositeKey(consignmentnoteIndexName, []string{strconv.Itoa(orderId)})
+	if err != nil {
+		return nil, err
+	}
+	noteJSON, err := ctx.GetStub().GetState(noteIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if noteJSON == nil {
+		return nil, nil
+	}
+
+	var record ConsignmentNoteRecord
+	err = json.Unmarshal(noteJSON, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+//putConsignmentNote writes the consignment note to the world state
+func putConsignmentNote(ctx contractapi.TransactionContextInterface, record *ConsignmentNoteRecord) error {
+	noteIndexKey, err := ctx.GetStub().CreateCompositeKey(consignmentnoteIndexName, []string{strconv.Itoa(record.OrderId)})
+	if err != nil {
+		return err
+	}
+	noteJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(noteIndexKey, noteJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//SetOrderConsignment records the parties, border points and customs declarations of the order orderId,
+//only by the organization of the order's customer or the operator while the order is booked and its consignment note unsigned.
+//The consignee's MSPID and identity are required together, the identity is the client id of the consignee's identity taking delivery.
+func (s *SmartContract) SetOrderConsignment(ctx contractapi.TransactionContextInterface, orderId int, consignor, consignee Party,
+	borderPoints []string, customsDeclarations []CustomsDeclaration) (Result, error) {
+	err := checkParty("consignor", consignor)
+	if err != nil {
+		return failure(err)
+	}
+	err = checkParty("consignee", consignee)
+	if err != nil {
+		return failure(err)
+	}
+	if (consignee.MSPID == "") != (consignee.Identity == "") {
+		return failure(errors.New("the consignee's MSPID and identity are required together"))
+	}
+	for _, declaration := range customsDeclarations {
+		if declaration.DeclarationNumber == "" || declaration.Country == "" {
+			return failure(errors.New("the customs declaration's number and country are required"))
+		}
+	}
+
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	err = assertOrderCustomer(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	//the parties are frozen once the goods are loaded or the consignment note is signed
+	if order.State != OrderStateBooked {
+		return failure(fmt.Errorf("the order %d is %s, only the consignment of a booked order could be set", orderId, order.State))
+	}
+	record, err := getConsignmentNote(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if record != nil && len(record.Signatures) != 0 {
+		return failure(fmt.Errorf("the consignment note of order %d has been signed by %s", orderId, record.Signatures[0].MSPID))
+	}
+	order.Consignor = consignor
+	order.Consignee = consignee
+	order.BorderPoints = borderPoints
+	order.CustomsDeclarations = customsDeclarations
+	err = putOrder(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//GenerateConsignmentNote renders the order orderId into a consignment note of type noteType (CIM or SMGS)
+//and stores its canonical json and hash, only by the organization of the order's customer or the operator.
+//A changed note replaces an earlier one only while the earlier one is unsigned.
+func (s *SmartContract) GenerateConsignmentNote(ctx contractapi.TransactionContextInterface, orderId int, noteType string) Result {
+	if noteType != NoteTypeCIM && noteType != NoteTypeSMGS {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the note type %s is neither %s nor %s", noteType, NoteTypeCIM, NoteTypeSMGS),
+		}
+	}
+
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = assertOrderCustomer(ctx, order)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkParty("consignor", order.Consignor)
+	if err == nil {
+		err = checkParty("consignee", order.Consignee)
+	}
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the order %d's consignment is incomplete: %v", orderId, err),
+		}
+	}
+	//SMGS notes are handed over at border points
+	if noteType == NoteTypeSMGS && len(order.BorderPoints) == 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the order %d has no border point required by %s", orderId, NoteTypeSMGS),
+		}
+	}
+
+	//an unchanged note keeps its issue date, hash and signatures
+	record, err := getConsignmentNote(ctx, orderId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if record != nil {
+		var issued ConsignmentNote
+		err = json.Unmarshal([]byte(record.Document), &issued)
+		if err != nil {
+			return Result{
+				Code: 402,
+				Msg:  err.Error(),
+			}
+		}
+		_, hash, err := renderConsignmentNote(order, noteType, issued.IssueDate)
+		if err != nil {
+			return Result{
+				Code: 402,
+				Msg:  err.Error(),
+			}
+		}
+		if hash == record.Hash {
+			return Result{
+				Code: 200,
+				Msg:  fmt.Sprintf("the consignment note of order %d is up to date", orderId),
+			}
+		}
+		//a signed note couldn't be replaced, or its signatures would be lost
+		if len(record.Signatures) != 0 {
+			return Result{
+				Code: 402,
+				Msg:  fmt.Sprintf("the consignment note of order %d has been signed by %s", orderId, record.Signatures[0].MSPID),
+			}
+		}
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	document, hash, err := renderConsignmentNote(order, noteType, txTime.Format("2006-01-02"))
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	record = &ConsignmentNoteRecord{
+		OrderId:    orderId,
+		NoteType:   noteType,
+		Document:   document,
+		Hash:       hash,
+		Signatures: []NoteSignature{},
+	}
+	err = putConsignmentNote(ctx, record)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//SignConsignmentNote signs off the consignment note of the order orderId on behalf of the caller's organization,
+//hash must be the hash of the stored note so that every organization signs the same document
+func (s *SmartContract) SignConsignmentNote(ctx contractapi.TransactionContextInterface, orderId int, hash string) Result {
+	record, err := getConsignmentNote(ctx, orderId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if record == nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the consignment note of order %d does not exist", orderId),
+		}
+	}
+	if record.Hash != hash {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the hash %s doesn't match the consignment note of order %d", hash, orderId),
+		}
+	}
+
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("failed to get client's MSPID: %v", err),
+		}
+	}
+	for _, signature := range record.Signatures {
+		if signature.MSPID == mspId {
+			return Result{
+				Code: 402,
+				Msg:  fmt.Sprintf("the consignment note of order %d has been signed by %s", orderId, mspId),
+			}
+		}
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	record.Signatures = append(record.Signatures, NoteSignature{
+		MSPID:    mspId,
+		SignTime: txTime.Format(time.RFC3339),
+	})
+	err = putConsignmentNote(ctx, record)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QueryConsignmentNoteByorderid returns the consignment note of the order in the world state with given orderId
+func (s *SmartContract) QueryConsignmentNoteByorderid(ctx contractapi.TransactionContextInterface, orderId int) ConsignmentNoteQueryResult {
+	record, err := getConsignmentNote(ctx, orderId)
+	if err != nil {
+		return ConsignmentNoteQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ConsignmentNoteRecord{Signatures: []NoteSignature{}},
+		}
+	}
+	if record == nil {
+		return ConsignmentNoteQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("the consignment note of order %d does not exist", orderId),
+			Data: ConsignmentNoteRecord{Signatures: []NoteSignature{}},
+		}
+	}
+	return ConsignmentNoteQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *record,
+	}
+}
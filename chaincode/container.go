@@ -0,0 +1,427 @@
+//@author: hdsfade
+//@date: 2021-02-25-10:05
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"time"
+)
+
+var containerIndexName = "container"
+var containereventIndexName = "container~event"
+
+//container events
+const (
+	ContainerEventLoad   = "load"   //装车
+	ContainerEventUnload = "unload" //卸车
+)
+
+//Container describes an ISO container, it is at the station Location or on the train TrainNumber loaded there
+type Container struct { //集装箱
+	ContainerNumber string   `json:"containerNumber"` //ISO 6346 owner code, category, serial number and check digit
+	SizeType        string   `json:"sizeType"`        //ISO 6346 size and type code, e.g. 22G1
+	Tare            int      `json:"tare"`            //kg
+	SealNumbers     []string `json:"sealNumbers"`
+	Location        string   `json:"location"`
+	TrainNumber     string   `json:"trainNumber"` //empty if the container is not loaded
+	OrderId         int      `json:"orderId"`     //the order the container is loaded for, 0 if not loaded
+	EventCount      int      `json:"eventCount"`
+}
+
+//ContainerEvent describes the container loaded on or unloaded from a train at a station
+type ContainerEvent struct { //集装箱动态
+	ContainerNumber string   `json:"containerNumber"`
+	Sequence        int      `json:"sequence"`
+	EventType       string   `json:"eventType"`
+	Station         string   `json:"station"`
+	TrainNumber     string   `json:"trainNumber"`
+	OrderId         int      `json:"orderId"`
+	SealNumbers     []string `json:"sealNumbers"`
+	EventTime       string   `json:"eventTime"`
+	Recorder        string   `json:"recorder"` //MSPID of the organization recording the event
+}
+
+type ContainerEvents struct {
+	EventsData []ContainerEvent `json:"events"`
+}
+
+//ContainerQueryResult structure used for handing result of query
+type ContainerQueryResult struct {
+	Code int       `json:"code"`
+	Msg  string    `json:"msg"`
+	Data Container `json:"data"`
+}
+
+//ContainerEventQueryResults structure used for handing result of query container history
+type ContainerEventQueryResults struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data ContainerEvents `json:"data"`
+}
+
+//iso6346LetterValues maps letters to their values in ISO 6346, multiples of 11 are skipped
+var iso6346LetterValues = map[byte]int{
+	'A': 10, 'B': 12, 'C': 13, 'D': 14, 'E': 15, 'F': 16, 'G': 17, 'H': 18, 'I': 19, 'J': 20, 'K': 21, 'L': 23, 'M': 24,
+	'N': 25, 'O': 26, 'P': 27, 'Q': 28, 'R': 29, 'S': 30, 'T': 31, 'U': 32, 'V': 34, 'W': 35, 'X': 36, 'Y': 37, 'Z': 38,
+}
+
+//checkContainerNumber judges an ISO 6346 container number if valid or not: 3 letters of the owner code,
+//the category U, J or Z, 6 digits of the serial number and the check digit, which is the sum of the first
+//10 characters' values weighted 2^i modulo 11 and then modulo 10
+func checkContainerNumber(containerNumber string) error {
+	if len(containerNumber) != 11 {
+		return fmt.Errorf("the container number %s doesn't have 11 characters", containerNumber)
+	}
+	category := containerNumber[3]
+	if category != 'U' && category != 'J' && category != 'Z' {
+		return fmt.Errorf("the container number %s's category %c is not U, J or Z", containerNumber, category)
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var value int
+		if i < 4 {
+			letterValue, ok := iso6346LetterValues[containerNumber[i]]
+			if !ok {
+				return fmt.Errorf("the container number %s's owner code and category must be capital letters", containerNumber)
+			}
+			value = letterValue
+		} else {
+			if containerNumber[i] < '0' || containerNumber[i] > '9' {
+				return fmt.Errorf("the container number %s's serial number must be digits", containerNumber)
+			}
+			value = int(containerNumber[i] - '0')
+		}
+		sum += value << uint(i)
+	}
+	checkDigit := sum % 11 % 10
+	if containerNumber[10] < '0' || containerNumber[10] > '9' || int(containerNumber[10]-'0') != checkDigit {
+		return fmt.Errorf("the container number %s's check digit must be %d", containerNumber, checkDigit)
+	}
+	return nil
+}
+
+//ContainerExists judges a container if exists or not
+func (s *SmartContract) ContainerExists(ctx contractapi.TransactionContextInterface, containerNumber string) (bool, error) {
+	containerIndexKey, err := ctx.GetStub().CreateCompositeKey(containerIndexName, []string{containerNumber})
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+	containerJSON, err := ctx.GetStub().GetState(containerIndexKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+	return containerJSON != nil, nil
+}
+
+//getContainer reads the container from the world state
+func getContainer(ctx contractapi.TransactionContextInterface, containerNumber string) (*Container, error) {
+	containerIndexKey, err := ctx.GetStub().CreateCompositeKey(containerIndexName, []string{containerNumber})
+	if err != nil {
+		return nil, err
+	}
+	containerJSON, err := ctx.GetStub().GetState(containerIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if containerJSON == nil {
+		return nil, fmt.Errorf("the container %s does not exist", containerNumber)
+	}
+
+	var container Container
+	err = json.Unmarshal(containerJSON, &container)
+	if err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+//putContainer writes the container to the world state
+func putContainer(ctx contractapi.TransactionContextInterface, container *Container) error {
+	containerIndexKey, err := ctx.GetStub().CreateCompositeKey(containerIndexName, []string{container.ContainerNumber})
+	if err != nil {
+		return err
+	}
+	containerJSON, err := json.Marshal(container)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(containerIndexKey, containerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//recordContainerEvent records the next event of the container at the station and writes the container
+func recordContainerEvent(ctx contractapi.TransactionContextInterface, container *Container, eventType, station string,
+	trainNumber string, orderId int) error {
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	container.EventCount++
+	event := ContainerEvent{
+		ContainerNumber: container.ContainerNumber,
+		Sequence:        container.EventCount,
+		EventType:       eventType,
+		Station:         station,
+		TrainNumber:     trainNumber,
+		OrderId:         orderId,
+		SealNumbers:     container.SealNumbers,
+		EventTime:       txTime.Format(time.RFC3339),
+		Recorder:        mspId,
+	}
+	eventIndexKey, err := ctx.GetStub().CreateCompositeKey(containereventIndexName,
+		[]string{container.ContainerNumber, fmt.Sprintf("%06d", event.Sequence)})
+	if err != nil {
+		return err
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(eventIndexKey, eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return putContainer(ctx, container)
+}
+
+//CreateContainer issues a new empty container at the station location to the world state
+func (s *SmartContract) CreateContainer(ctx contractapi.TransactionContextInterface, containerNumber, sizeType string, tare int,
+	location string) Result {
+	err := checkContainerNumber(containerNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if len(sizeType) != 4 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the size and type code %s doesn't have 4 characters", sizeType),
+		}
+	}
+	if tare <= 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the container's tare %d must be positive", tare),
+		}
+	}
+	exists, err := s.ContainerExists(ctx, containerNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if exists {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the container %s already exists", containerNumber),
+		}
+	}
+	exists, err = s.StationExists(ctx, location)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if !exists {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the station %s does not exist", location),
+		}
+	}
+
+	container := Container{
+		ContainerNumber: containerNumber,
+		SizeType:        sizeType,
+		Tare:            tare,
+		SealNumbers:     []string{},
+		Location:        location,
+		TrainNumber:     "",
+		OrderId:         0,
+		EventCount:      0,
+	}
+	err = putContainer(ctx, &container)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//LoadContainer loads the container with the seals sealNumbers on the train of the order at the order's starting station,
+//the seals are checked by every cargo check of the train and the order references the containers loaded for it,
+//and a failure is returned as an error so that no write of the transaction is committed
+func (s *SmartContract) LoadContainer(ctx contractapi.TransactionContextInterface, containerNumber string, orderId int,
+	station string, sealNumbers []string) (Result, error) {
+	container, err := getContainer(ctx, containerNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if container.TrainNumber != "" {
+		return failure(fmt.Errorf("the container %s is loaded on the train %s", containerNumber, container.TrainNumber))
+	}
+	if container.Location != station {
+		return failure(fmt.Errorf("the container %s is at %s, not at %s", containerNumber, container.Location, station))
+	}
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if order.State != OrderStateBooked && order.State != OrderStateLoaded {
+		return failure(fmt.Errorf("the order %d is %s, containers could only be loaded for a booked or loaded order", orderId, order.State))
+	}
+	if order.StartingStation != station {
+		return failure(fmt.Errorf("the order %d starts from %s, not from %s", orderId, order.StartingStation, station))
+	}
+
+	//the seals are checked before the container is loaded
+	err = checkNewSeals(ctx, order.TrainNumber, sealNumbers)
+	if err != nil {
+		return failure(err)
+	}
+
+	if sealNumbers == nil {
+		sealNumbers = []string{}
+	}
+	container.SealNumbers = sealNumbers
+	container.TrainNumber = order.TrainNumber
+	container.OrderId = orderId
+	err = recordContainerEvent(ctx, container, ContainerEventLoad, station, order.TrainNumber, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	err = affixSeals(ctx, order.TrainNumber, containerNumber, 0, orderId, station, sealNumbers)
+	if err != nil {
+		return failure(err)
+	}
+
+	if !containsString(order.Containers, containerNumber) {
+		order.Containers = append(order.Containers, containerNumber)
+		err = putOrder(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//UnloadContainer unloads the container from its train at the destination station of its order,
+//or at the station an offloaded order was taken off the train, a failure is returned as an error
+func (s *SmartContract) UnloadContainer(ctx contractapi.TransactionContextInterface, containerNumber, station string) (Result, error) {
+	container, err := getContainer(ctx, containerNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if container.TrainNumber == "" {
+		return failure(fmt.Errorf("the container %s is not loaded", containerNumber))
+	}
+	order, err := getOrder(ctx, container.OrderId)
+	if err != nil {
+		return failure(err)
+	}
+	if station != order.DestinationStation && station != order.OffloadStation {
+		return failure(fmt.Errorf("the container %s of the order %d couldn't be unloaded at %s", containerNumber, order.OrderId, station))
+	}
+
+	trainNumber := container.TrainNumber
+	container.Location = station
+	container.TrainNumber = ""
+	container.OrderId = 0
+	err = recordContainerEvent(ctx, container, ContainerEventUnload, station, trainNumber, order.OrderId)
+	if err != nil {
+		return failure(err)
+	}
+	err = removeContainerSeals(ctx, trainNumber, containerNumber, station)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryContainerBycontainernumber returns the container in the world state with given containerNumber
+func (s *SmartContract) QueryContainerBycontainernumber(ctx contractapi.TransactionContextInterface, containerNumber string) ContainerQueryResult {
+	container, err := getContainer(ctx, containerNumber)
+	if err != nil {
+		return ContainerQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Container{SealNumbers: []string{}},
+		}
+	}
+	return ContainerQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *container,
+	}
+}
+
+//QueryContainerHistory returns all loading and unloading events of the container across trains in order
+func (s *SmartContract) QueryContainerHistory(ctx contractapi.TransactionContextInterface, containerNumber string) ContainerEventQueryResults {
+	eventResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(containereventIndexName, []string{containerNumber})
+	if err != nil {
+		return ContainerEventQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ContainerEvents{EventsData: []ContainerEvent{}},
+		}
+	}
+	defer eventResultsIterator.Close()
+
+	events := []ContainerEvent{}
+	for eventResultsIterator.HasNext() {
+		eventQueryResponse, err := eventResultsIterator.Next()
+		if err != nil {
+			return ContainerEventQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: ContainerEvents{EventsData: []ContainerEvent{}},
+			}
+		}
+		var event ContainerEvent
+		err = json.Unmarshal(eventQueryResponse.Value, &event)
+		if err != nil {
+			return ContainerEventQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: ContainerEvents{EventsData: []ContainerEvent{}},
+			}
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return ContainerEventQueryResults{
+			Code: 402,
+			Msg:  fmt.Sprintf("the container %s has no event", containerNumber),
+			Data: ContainerEvents{EventsData: []ContainerEvent{}},
+		}
+	}
+	return ContainerEventQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: ContainerEvents{EventsData: events},
+	}
+}
@@ -0,0 +1,459 @@
+//@author: hdsfade
+//@date: 2021-02-10-14:36
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+)
+
+var customerIndexName = "customer"
+var customerorderIndexName = "customer~order"
+
+//customer states
+const (
+	CustomerStatusActive    = "active"    //正常
+	CustomerStatusSuspended = "suspended" //已暂停
+)
+
+//payment terms of customers
+const (
+	PaymentTermsPrepayment = "prepayment" //预付
+	PaymentTermsCredit     = "credit"     //信用
+)
+
+//Customer describes details of a customer(shipper)
+type Customer struct { //客户
+	CustomerId      int    `json:"customerId"`
+	LegalName       string `json:"legalName"`
+	Country         string `json:"country"`
+	TaxId           string `json:"taxId"`
+	Contact         string `json:"contact"`
+	CreditLimit     int64  `json:"creditLimit"` //in minor units of the billing currency
+	BillingCurrency string `json:"billingCurrency"`
+	PaymentTerms    string `json:"paymentTerms"`
+	Status          string `json:"status"`
+	MSPID           string `json:"mspId"` //organization of the customer's identities
+}
+
+type Customers struct {
+	CustomersData []Customer `json:"customers"`
+}
+
+//CustomerQueryResult structure used for handing result of query
+type CustomerQueryResult struct {
+	Code int      `json:"code"`
+	Msg  string   `json:"msg"`
+	Data Customer `json:"data"`
+}
+
+//CustomerQueryResults structure used for handing result of queryAll
+type CustomerQueryResults struct {
+	Code int       `json:"code"`
+	Msg  string    `json:"msg"`
+	Data Customers `json:"data"`
+}
+
+//CustomerExists judges a customer if exists or not
+func (s *SmartContract) CustomerExists(ctx contractapi.TransactionContextInterface, customerId int) (bool, error) {
+	customerIndexKey, err := ctx.GetStub().CreateCompositeKey(customerIndexName, []string{strconv.Itoa(customerId)})
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+
+	customerJSON, err := ctx.GetStub().GetState(customerIndexKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+	return customerJSON != nil, nil
+}
+
+//getCustomer reads the customer with given customerId from the world state
+func getCustomer(ctx contractapi.TransactionContextInterface, customerId int) (*Customer, error) {
+	customerIndexKey, err := ctx.GetStub().CreateCompositeKey(customerIndexName, []string{strconv.Itoa(customerId)})
+	if err != nil {
+		return nil, err
+	}
+	customerJSON, err := ctx.GetStub().GetState(customerIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if customerJSON == nil {
+		return nil, fmt.Errorf("the customer %d does not exist", customerId)
+	}
+
+	var customer Customer
+	err = json.Unmarshal(customerJSON, &customer)
+	if err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+//putCustomer writes the customer to the world state
+func putCustomer(ctx contractapi.TransactionContextInterface, customer *Customer) error {
+	customerIndexKey, err := ctx.GetStub().CreateCompositeKey(customerIndexName, []string{strconv.Itoa(customer.CustomerId)})
+	if err != nil {
+		return err
+	}
+	customerJSON, err := json.Marshal(customer)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(customerIndexKey, customerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//putCustomerOrder creates compositekey customer~order in the private data collection of the order
+func putCustomerOrder(ctx contractapi.TransactionContextInterface, collection string, customerId, orderId int) error {
+	customerorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		customerorderIndexName, []string{strconv.Itoa(customerId), strconv.Itoa(orderId)})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(collection, customerorderIndexKey, []byte(strconv.Itoa(orderId)))
+}
+
+//delCustomerOrder deletes compositekey customer~order from the private data collection of the order
+func delCustomerOrder(ctx contractapi.TransactionContextInterface, collection string, customerId, orderId int) error {
+	customerorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		customerorderIndexName, []string{strconv.Itoa(customerId), strconv.Itoa(orderId)})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelPrivateData(collection, customerorderIndexKey)
+}
+
+//customerOrderIds returns ids of all orders of the customer
+func customerOrderIds(ctx contractapi.TransactionContextInterface, customer *Customer) ([]int, error) {
+	orderResultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(
+		orderCollectionName(customer.MSPID), customerorderIndexName, []string{strconv.Itoa(customer.CustomerId)})
+	if err != nil {
+		return nil, err
+	}
+	defer orderResultsIterator.Close()
+
+	orderIds := []int{}
+	for orderResultsIterator.HasNext() {
+		orderQueryResponse, err := orderResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		orderId, err := strconv.Atoi(string(orderQueryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		orderIds = append(orderIds, orderId)
+	}
+	return orderIds, nil
+}
+
+//customerOutstanding returns the amount of the customer's active reservations and orders not paid yet in its billing currency
+func customerOutstanding(ctx contractapi.TransactionContextInterface, customer *Customer) (int64, error) {
+	balance, err := customerBalance(ctx, customer)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Reserved + balance.Uninvoiced + balance.Outstanding, nil
+}
+
+//assertOrderCustomer judges the client if belongs to the organization of the order's customer or the operator or not
+func assertOrderCustomer(ctx contractapi.TransactionContextInterface, order *Order) error {
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	if mspId == operatorMSPID {
+		return nil
+	}
+	details, err := getOrderPrivateDetails(ctx, order)
+	if err != nil {
+		return err
+	}
+	customer, err := getCustomer(ctx, details.CustomerId)
+	if err != nil {
+		return err
+	}
+	if mspId != customer.MSPID {
+		return fmt.Errorf("the organization %s is neither the customer %d of the order %d nor the operator", mspId, customer.CustomerId, order.OrderId)
+	}
+	return nil
+}
+
+//checkCustomerOrder judges the customer if could place an order of price (in its billing currency) or not,
+//the client must belong to the customer's organization or the operator
+func checkCustomerOrder(ctx contractapi.TransactionContextInterface, customer *Customer, price int64) error {
+	if customer.Status != CustomerStatusActive {
+		return fmt.Errorf("the customer %d is %s", customer.CustomerId, customer.Status)
+	}
+
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	if mspId != customer.MSPID && mspId != operatorMSPID {
+		return fmt.Errorf("the organization %s couldn't create orders for the customer %d", mspId, customer.CustomerId)
+	}
+
+	outstanding, err := customerOutstanding(ctx, customer)
+	if err != nil {
+		return err
+	}
+	if outstanding+price > customer.CreditLimit {
+		return fmt.Errorf("the customer %d's credit limit is not enough: creditLimit %d, outstanding %d, price %d",
+			customer.CustomerId, customer.CreditLimit, outstanding, price)
+	}
+	return nil
+}
+
+//checkCustomer judges details of a customer if valid or not
+func checkCustomer(legalName, country, mspId string, creditLimit int64, billingCurrency, paymentTerms string) error {
+	if legalName == "" || country == "" || mspId == "" {
+		return fmt.Errorf("the customer's legal name, country and MSPID are required")
+	}
+	if paymentTerms != PaymentTermsPrepayment && paymentTerms != PaymentTermsCredit {
+		return fmt.Errorf("the payment terms %s is neither %s nor %s", paymentTerms, PaymentTermsPrepayment, PaymentTermsCredit)
+	}
+	if creditLimit < 0 {
+		return fmt.Errorf("the customer's credit limit %d must not be negative", creditLimit)
+	}
+	return checkCurrency(billingCurrency)
+}
+
+//CreateCustomer issues a new customer to the world state with given details, only the operator could create customers
+func (s *SmartContract) CreateCustomer(ctx contractapi.TransactionContextInterface, customerId int, legalName, country, taxId,
+	contact string, creditLimit int64, billingCurrency, paymentTerms, mspId string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkCustomer(legalName, country, mspId, creditLimit, billingCurrency, paymentTerms)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	exists, err := s.CustomerExists(ctx, customerId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if exists {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the customer %d already exists", customerId),
+		}
+	}
+
+	customer := Customer{
+		CustomerId:      customerId,
+		LegalName:       legalName,
+		Country:         country,
+		TaxId:           taxId,
+		Contact:         contact,
+		CreditLimit:     creditLimit,
+		BillingCurrency: billingCurrency,
+		PaymentTerms:    paymentTerms,
+		Status:          CustomerStatusActive,
+		MSPID:           mspId,
+	}
+	err = putCustomer(ctx, &customer)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//UpdateCustomer updates an existing customer in the world state with provided parameters
+func (s *SmartContract) UpdateCustomer(ctx contractapi.TransactionContextInterface, customerId int, legalName, country, taxId,
+	contact string, creditLimit int64, billingCurrency, paymentTerms, mspId string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkCustomer(legalName, country, mspId, creditLimit, billingCurrency, paymentTerms)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	//orders of the customer are kept in the collection of its organization
+	if mspId != customer.MSPID {
+		orderIds, err := customerOrderIds(ctx, customer)
+		if err != nil {
+			return Result{
+				Code: 402,
+				Msg:  err.Error(),
+			}
+		}
+		if len(orderIds) > 0 {
+			return Result{
+				Code: 402,
+				Msg:  fmt.Sprintf("the customer %d has orders %v, its MSPID couldn't be changed", customerId, orderIds),
+			}
+		}
+	}
+
+	//overwriting original details
+	customer.LegalName = legalName
+	customer.Country = country
+	customer.TaxId = taxId
+	customer.Contact = contact
+	customer.CreditLimit = creditLimit
+	customer.BillingCurrency = billingCurrency
+	customer.PaymentTerms = paymentTerms
+	customer.MSPID = mspId
+	err = putCustomer(ctx, customer)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//SuspendCustomer suspends the customer, a suspended customer couldn't place orders
+func (s *SmartContract) SuspendCustomer(ctx contractapi.TransactionContextInterface, customerId int) Result {
+	return s.setCustomerStatus(ctx, customerId, CustomerStatusSuspended)
+}
+
+//ReactivateCustomer reactivates a suspended customer
+func (s *SmartContract) ReactivateCustomer(ctx contractapi.TransactionContextInterface, customerId int) Result {
+	return s.setCustomerStatus(ctx, customerId, CustomerStatusActive)
+}
+
+func (s *SmartContract) setCustomerStatus(ctx contractapi.TransactionContextInterface, customerId int, status string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if customer.Status == status {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the customer %d is already %s", customerId, status),
+		}
+	}
+
+	customer.Status = status
+	err = putCustomer(ctx, customer)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QueryCustomerBycustomerid returns the customer in the world state with given customerId
+func (s *SmartContract) QueryCustomerBycustomerid(ctx contractapi.TransactionContextInterface, customerId int) CustomerQueryResult {
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return CustomerQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Customer{},
+		}
+	}
+	return CustomerQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *customer,
+	}
+}
+
+//QueryAllCustomers returns all customers found in world state
+func (s *SmartContract) QueryAllCustomers(ctx contractapi.TransactionContextInterface) CustomerQueryResults {
+	customerResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(customerIndexName, []string{})
+	if err != nil {
+		return CustomerQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Customers{CustomersData: []Customer{}},
+		}
+	}
+	defer customerResultsIterator.Close()
+
+	var customers []Customer
+	for customerResultsIterator.HasNext() {
+		customerQueryResponse, err := customerResultsIterator.Next()
+		if err != nil {
+			return CustomerQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Customers{CustomersData: []Customer{}},
+			}
+		}
+
+		var customer Customer
+		err = json.Unmarshal(customerQueryResponse.Value, &customer)
+		if err != nil {
+			return CustomerQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Customers{CustomersData: []Customer{}},
+			}
+		}
+		customers = append(customers, customer)
+	}
+	if customers == nil {
+		return CustomerQueryResults{
+			Code: 402,
+			Msg:  "No customer",
+			Data: Customers{CustomersData: []Customer{}},
+		}
+	}
+
+	return CustomerQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Customers{CustomersData: customers},
+	}
+}
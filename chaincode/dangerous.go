@@ -0,0 +1,344 @@
+//@author: hdsfade
+//@date: 2021-02-26-10:40
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strings"
+)
+
+var segregationIndexName = "segregation"
+
+//segregation levels
+const (
+	SegregationTrain    = "train"    //not on the same train
+	SegregationCarriage = "carriage" //not in the same carriage
+)
+
+//hazardClasses are the ADR/RID classes and divisions of dangerous goods
+var hazardClasses = []string{"1", "1.1", "1.2", "1.3", "1.4", "1.5", "1.6", "2", "2.1", "2.2", "2.3", "3", "4.1", "4.2", "4.3",
+	"5.1", "5.2", "6.1", "6.2", "7", "8", "9"}
+
+//SegregationRule describes how dangerous goods of two classes must be kept apart,
+//a rule of a class applies to its divisions unless a division has a rule of its own
+type SegregationRule struct { //隔离规则
+	ClassA string `json:"classA"`
+	ClassB string `json:"classB"`
+	Level  string `json:"level"`
+}
+
+type SegregationRules struct {
+	RulesData []SegregationRule `json:"rules"`
+}
+
+//SegregationQueryResults structure used for handing result of query segregation table
+type SegregationQueryResults struct {
+	Code int              `json:"code"`
+	Msg  string           `json:"msg"`
+	Data SegregationRules `json:"data"`
+}
+
+//checkHazardClass judges the class if is an ADR/RID class or division or not
+func checkHazardClass(class string) error {
+	if !containsString(hazardClasses, class) {
+		return fmt.Errorf("the hazard class %s is not one of %s", class, strings.Join(hazardClasses, ", "))
+	}
+	return nil
+}
+
+//checkDangerousGoods judges the UN numbers and hazard classes of the goods goodsName if valid or not and returns them
+//with UN numbers formatted as UN followed by 4 digits. Both are empty for goods which are not dangerous,
+//and both may be empty for an order without dangerous goods.
+func checkDangerousGoods(goodsName, unNumber, hazardClass []string) ([]string, []string, error) {
+	if len(unNumber) == 0 && len(hazardClass) == 0 {
+		return []string{}, []string{}, nil
+	}
+	if len(unNumber) != len(goodsName) || len(hazardClass) != len(goodsName) {
+		return nil, nil, fmt.Errorf("the order has %d goods but %d UN numbers and %d hazard classes",
+			len(goodsName), len(unNumber), len(hazardClass))
+	}
+
+	numbers := make([]string, len(unNumber))
+	for i := range goodsName {
+		if unNumber[i] == "" && hazardClass[i] == "" {
+			continue
+		}
+		number := strings.TrimPrefix(unNumber[i], "UN")
+		if len(number) != 4 || strings.Trim(number, "0123456789") != "" {
+			return nil, nil, fmt.Errorf("the UN number %s of the goods %s is not 4 digits", unNumber[i], goodsName[i])
+		}
+		err := checkHazardClass(hazardClass[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("the goods %s: %v", goodsName[i], err)
+		}
+		numbers[i] = "UN" + number
+	}
+	return numbers, hazardClass, nil
+}
+
+//segregationKey returns the key of the rule of two classes, which doesn't depend on their order
+func segregationKey(ctx contractapi.TransactionContextInterface, classA, classB string) (string, error) {
+	if classA > classB {
+		classA, classB = classB, classA
+	}
+	return ctx.GetStub().CreateCompositeKey(segregationIndexName, []string{classA, classB})
+}
+
+//getSegregationRule reads the rule of two classes from the world state, nil if there is none
+func getSegregationRule(ctx contractapi.TransactionContextInterface, classA, classB string) (*SegregationRule, error) {
+	ruleIndexKey, err := segregationKey(ctx, classA, classB)
+	if err != nil {
+		return nil, err
+	}
+	ruleJSON, err := ctx.GetStub().GetState(ruleIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if ruleJSON == nil {
+		return nil, nil
+	}
+
+	var rule SegregationRule
+	err = json.Unmarshal(ruleJSON, &rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+//segregationLevel returns the level two classes must be kept apart at, empty if they may be loaded together.
+//The rule of the classes applies, or else the rule of their main classes.
+func segregationLevel(ctx contractapi.TransactionContextInterface, classA, classB string) (string, error) {
+	mainA, mainB := strings.Split(classA, ".")[0], strings.Split(classB, ".")[0]
+	candidates := [][2]string{{classA, classB}, {mainA, classB}, {classA, mainB}, {mainA, mainB}}
+	for _, candidate := range candidates {
+		rule, err := getSegregationRule(ctx, candidate[0], candidate[1])
+		if err != nil {
+			return "", err
+		}
+		if rule != nil {
+			return rule.Level, nil
+		}
+	}
+	return "", nil
+}
+
+//orderHazardClasses returns the distinct hazard classes of the order's goods
+func orderHazardClasses(order *Order) []string {
+	classes := []string{}
+	for _, class := range order.HazardClass {
+		if class != "" && !containsString(classes, class) {
+			classes = append(classes, class)
+		}
+	}
+	return classes
+}
+
+//sharesCarriage judges two orders if share a carriage or not
+func sharesCarriage(orderA, orderB *Order) bool {
+	for _, position := range orderA.Carriages {
+		if containsInt(orderB.Carriages, position) {
+			return true
+		}
+	}
+	return false
+}
+
+//checkSegregation judges the dangerous goods of the orders on the train if violate the segregation table or not.
+//Goods of an order share its carriages, so classes kept apart at the carriage level couldn't be in one order.
+func checkSegregation(ctx contractapi.TransactionContextInterface, orders []*Order) error {
+	for i, orderA := range orders {
+		classesA := orderHazardClasses(orderA)
+		for j := i; j < len(orders); j++ {
+			orderB := orders[j]
+			classesB := orderHazardClasses(orderB)
+			for a, classA := range classesA {
+				for b, classB := range classesB {
+					if i == j && b <= a {
+						continue
+					}
+					level, err := segregationLevel(ctx, classA, classB)
+					if err != nil {
+						return err
+					}
+					if level == SegregationTrain && i != j {
+						return fmt.Errorf("the class %s of the order %d and the class %s of the order %d must not be on the same train",
+							classA, orderA.OrderId, classB, orderB.OrderId)
+					}
+					if level != "" && (i == j || sharesCarriage(orderA, orderB)) {
+						return fmt.Errorf("the class %s of the order %d and the class %s of the order %d must not be in the same carriage",
+							classA, orderA.OrderId, classB, orderB.OrderId)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//checkTrainSegregation judges the new order if could be loaded together with the orders on its train or not
+func checkTrainSegregation(ctx contractapi.TransactionContextInterface, order *Order) error {
+	if len(orderHazardClasses(order)) == 0 {
+		return nil
+	}
+	orderIds, err := trainOrderIds(ctx, order.TrainNumber)
+	if err != nil {
+		return err
+	}
+	orders := []*Order{order}
+	for _, orderId := range orderIds {
+		trainOrder, err := getOrder(ctx, orderId)
+		if err != nil {
+			return err
+		}
+		if trainOrder.OrderId != order.OrderId && !orderOffTrain(trainOrder) {
+			orders = append(orders, trainOrder)
+		}
+	}
+	return checkSegregation(ctx, orders)
+}
+
+//SetSegregationRule sets the level (train or carriage) two hazard classes must be kept apart at, only by the operator
+func (s *SmartContract) SetSegregationRule(ctx contractapi.TransactionContextInterface, classA, classB, level string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkHazardClass(classA)
+	if err == nil {
+		err = checkHazardClass(classB)
+	}
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if level != SegregationTrain && level != SegregationCarriage {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the segregation level %s is neither %s nor %s", level, SegregationTrain, SegregationCarriage),
+		}
+	}
+
+	if classA > classB {
+		classA, classB = classB, classA
+	}
+	ruleIndexKey, err := segregationKey(ctx, classA, classB)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	ruleJSON, err := json.Marshal(SegregationRule{
+		ClassA: classA,
+		ClassB: classB,
+		Level:  level,
+	})
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = ctx.GetStub().PutState(ruleIndexKey, ruleJSON)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//DeleteSegregationRule deletes the rule of two hazard classes, only by the operator
+func (s *SmartContract) DeleteSegregationRule(ctx contractapi.TransactionContextInterface, classA, classB string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	rule, err := getSegregationRule(ctx, classA, classB)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if rule == nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the classes %s and %s have no segregation rule", classA, classB),
+		}
+	}
+
+	ruleIndexKey, err := segregationKey(ctx, classA, classB)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = ctx.GetStub().DelState(ruleIndexKey)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QuerySegregationTable returns all segregation rules
+func (s *SmartContract) QuerySegregationTable(ctx contractapi.TransactionContextInterface) SegregationQueryResults {
+	ruleResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(segregationIndexName, []string{})
+	if err != nil {
+		return SegregationQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: SegregationRules{RulesData: []SegregationRule{}},
+		}
+	}
+	defer ruleResultsIterator.Close()
+
+	rules := []SegregationRule{}
+	for ruleResultsIterator.HasNext() {
+		ruleQueryResponse, err := ruleResultsIterator.Next()
+		if err != nil {
+			return SegregationQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: SegregationRules{RulesData: []SegregationRule{}},
+			}
+		}
+		var rule SegregationRule
+		err = json.Unmarshal(ruleQueryResponse.Value, &rule)
+		if err != nil {
+			return SegregationQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: SegregationRules{RulesData: []SegregationRule{}},
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return SegregationQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: SegregationRules{RulesData: rules},
+	}
+}
@@ -0,0 +1,276 @@
+//@author: hdsfade
+//@date: 2021-02-26-20:30
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
+)
+
+var deliveryIndexName = "delivery"
+
+//ProofOfDelivery describes the receipt of an order's goods by the consignee at the destination station
+type ProofOfDelivery struct { //交付凭证
+	OrderId       int      `json:"orderId"`
+	Station       string   `json:"station"`
+	ReceiveTime   string   `json:"receiveTime"`
+	Receiver      string   `json:"receiver"` //identity of the consignee's client
+	ReceiverMSPID string   `json:"receiverMspId"`
+	GoodsName     []string `json:"goodsName"`
+	DeclaredNum   []int    `json:"declaredNum"`
+	ReceivedNum   []int    `json:"receivedNum"`
+	DamageNotes   string   `json:"damageNotes"`
+	SignatureHash string   `json:"signatureHash"` //hex encoded sha256 of the consignee's signature
+	Discrepancy   bool     `json:"discrepancy"`   //goods short, over or damaged
+}
+
+//DeliveryQueryResult structure used for handing result of query
+type DeliveryQueryResult struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data ProofOfDelivery `json:"data"`
+}
+
+//getProofOfDelivery reads the proof of delivery of the order orderId, nil if the order has not been delivered
+func getProofOfDelivery(ctx contractapi.TransactionContextInterface, orderId int) (*ProofOfDelivery, error) {
+	deliveryIndexKey, err := ctx.GetStub().CreateCompositeKey(deliveryIndexName, []string{strconv.Itoa(orderId)})
+	if err != nil {
+		return nil, err
+	}
+	deliveryJSON, err := ctx.GetStub().GetState(deliveryIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if deliveryJSON == nil {
+		return nil, nil
+	}
+
+	var delivery ProofOfDelivery
+	err = json.Unmarshal(deliveryJSON, &delivery)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+//putProofOfDelivery writes the proof of delivery to the world state
+func putProofOfDelivery(ctx contractapi.TransactionContextInterface, delivery *ProofOfDelivery) error {
+	deliveryIndexKey, err := ctx.GetStub().CreateCompositeKey(deliveryIndexName, []string{strconv.Itoa(delivery.OrderId)})
+	if err != nil {
+		return err
+	}
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(deliveryIndexKey, deliveryJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//assertConsignee judges the client if is the identity of the order's consignee in the consignee's organization or not
+func assertConsignee(ctx contractapi.TransactionContextInterface, order *Order) error {
+	if order.Consignee.MSPID == "" || order.Consignee.Identity == "" {
+		return fmt.Errorf("the consignee of the order %d has no MSPID or identity", order.OrderId)
+	}
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	if mspId != order.Consignee.MSPID {
+		return fmt.Errorf("the organization %s is not the consignee %s of the order %d", mspId, order.Consignee.MSPID, order.OrderId)
+	}
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's identity: %v", err)
+	}
+	if id != order.Consignee.Identity {
+		return fmt.Errorf("the client is not the identity of the consignee of the order %d", order.OrderId)
+	}
+	return nil
+}
+
+//DeliverOrder records the receipt of the order's goods by its consignee at the destination station,
+//only the consignee's identity could take delivery once the train is at the destination station.
+//receivedNum gives the number of every goods received in the order of goodsName, and signatureHash
+//is the hex encoded sha256 of the consignee's signature kept off the ledger.
+//The order is delivered once and its carriages are free for the rest of the line,
+//a failure is returned as an error so that no write of the transaction is committed.
+func (s *SmartContract) DeliverOrder(ctx contractapi.TransactionContextInterface, orderId int, receivedNum []int,
+	damageNotes, signatureHash string) (Result, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	err = assertConsignee(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	delivered, err := getProofOfDelivery(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if delivered != nil {
+		return failure(fmt.Errorf("the order %d has been delivered at %s", orderId, delivered.ReceiveTime))
+	}
+	if order.State != OrderStateLoaded {
+		return failure(fmt.Errorf("the order %d is %s, only a loaded order could be delivered", orderId, order.State))
+	}
+	if order.NextLegOrderId != 0 {
+		return failure(fmt.Errorf("the order %d hands its goods over to the order %d at %s by HandOverLeg", orderId, order.NextLegOrderId, order.DestinationStation))
+	}
+	if len(receivedNum) != len(order.GoodsNum) {
+		return failure(fmt.Errorf("the order %d has %d goods but %d received numbers", orderId, len(order.GoodsNum), len(receivedNum)))
+	}
+	signature, err := hex.DecodeString(signatureHash)
+	if err != nil || len(signature) != 32 {
+		return failure(fmt.Errorf("the signature hash %s is not a hex encoded sha256", signatureHash))
+	}
+
+	waybill, err := getWayBill(ctx, order.TrainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if waybill.currentStation() != order.DestinationStation {
+		return failure(fmt.Errorf("the train %s is at %s, not the destination station %s of the order %d", order.TrainNumber, waybill.currentStation(), order.DestinationStation, orderId))
+	}
+
+	receiver, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return failure(fmt.Errorf("failed to get client's identity: %v", err))
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	delivery := ProofOfDelivery{
+		OrderId:       orderId,
+		Station:       order.DestinationStation,
+		ReceiveTime:   txTime.Format(time.RFC3339),
+		Receiver:      receiver,
+		ReceiverMSPID: order.Consignee.MSPID,
+		GoodsName:     order.GoodsName,
+		DeclaredNum:   order.GoodsNum,
+		ReceivedNum:   receivedNum,
+		DamageNotes:   damageNotes,
+		SignatureHash: hex.EncodeToString(signature),
+		Discrepancy:   damageNotes != "",
+	}
+	for i, num := range receivedNum {
+		if num < 0 {
+			return failure(fmt.Errorf("the received number %d of the goods %s is negative", num, order.GoodsName[i]))
+		}
+		if num != order.GoodsNum[i] {
+			delivery.Discrepancy = true
+		}
+	}
+
+	err = releaseCarriages(ctx, order.TrainNumber, order.Carriages, orderId, "")
+	if err != nil {
+		return failure(err)
+	}
+	order.State = OrderStateDelivered
+	err = putOrder(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	err = putProofOfDelivery(ctx, &delivery)
+	if err != nil {
+		return failure(err)
+	}
+	if delivery.Discrepancy {
+		return Result{
+			Code: 200,
+			Msg:  fmt.Sprintf("the order %d is delivered with discrepancies", orderId),
+		}, nil
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//HandOverLeg records that a leg of a multi-leg order handed its goods over to the next leg at its destination station,
+//only the operator could hand a leg over once its train has reached the station and the next leg is loaded.
+//The leg is delivered without a proof of delivery, which is taken by the consignee of the final leg,
+//and its carriages are free for the rest of the line. A failure is returned as an error so that no write is committed.
+func (s *SmartContract) HandOverLeg(ctx contractapi.TransactionContextInterface, orderId int) (Result, error) {
+	err := assertOperator(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if order.NextLegOrderId == 0 {
+		return failure(fmt.Errorf("the order %d is not followed by another leg, it is delivered to its consignee", orderId))
+	}
+	if order.State != OrderStateLoaded {
+		return failure(fmt.Errorf("the order %d is %s, only a loaded order could be handed over", orderId, order.State))
+	}
+	nextLeg, err := getOrder(ctx, order.NextLegOrderId)
+	if err != nil {
+		return failure(err)
+	}
+	if nextLeg.State == OrderStateBooked {
+		return failure(fmt.Errorf("the next leg %d of the order %d hasn't been loaded on the train %s", nextLeg.OrderId, orderId, nextLeg.TrainNumber))
+	}
+
+	waybill, err := getWayBill(ctx, order.TrainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	reached := false
+	for i, wayStation := range waybill.WayStation {
+		if wayStation == order.DestinationStation {
+			reached = i <= waybill.Location
+			break
+		}
+	}
+	if !reached {
+		return failure(fmt.Errorf("the train %s hasn't reached the handover station %s of the order %d", order.TrainNumber, order.DestinationStation, orderId))
+	}
+
+	err = releaseCarriages(ctx, order.TrainNumber, order.Carriages, orderId, "")
+	if err != nil {
+		return failure(err)
+	}
+	order.State = OrderStateDelivered
+	err = putOrder(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryProofOfDelivery returns the proof of delivery of the order in the world state with given orderId
+func (s *SmartContract) QueryProofOfDelivery(ctx contractapi.TransactionContextInterface, orderId int) DeliveryQueryResult {
+	delivery, err := getProofOfDelivery(ctx, orderId)
+	if err != nil {
+		return DeliveryQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if delivery == nil {
+		return DeliveryQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("the order %d has not been delivered", orderId),
+		}
+	}
+	return DeliveryQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *delivery,
+	}
+}
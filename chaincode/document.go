@@ -0,0 +1,320 @@
+//@author: hdsfade
+//@date: 2021-02-08-11:05
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var documentIndexName = "document"
+
+//types of records documents are anchored to
+const (
+	DocumentRecordOrder       = "order"       //订单
+	DocumentRecordInspection  = "inspection"  //货物检查
+	DocumentRecordWayBillStop = "waybillstop" //运单停靠站
+)
+
+//DocumentRef describes an off-chain document anchored to a record by its content hash
+type DocumentRef struct { //单证
+	RecordType string `json:"recordType"`
+	RecordId   string `json:"recordId"`
+	Hash       string `json:"hash"` //hex encoded sha256 of the document content
+	MediaType  string `json:"mediaType"`
+	Size       int64  `json:"size"`
+	URI        string `json:"uri"`
+	Uploader   string `json:"uploader"`
+	AnchorTime string `json:"anchorTime"`
+	TxId       string `json:"txId"`
+}
+
+type DocumentRefs struct {
+	DocumentsData []DocumentRef `json:"documents"`
+}
+
+//DocumentQueryResult structure used for handing result of query
+type DocumentQueryResult struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data DocumentRef `json:"data"`
+}
+
+//DocumentQueryResults structure used for handing result of queryAll
+type DocumentQueryResults struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data DocumentRefs `json:"data"`
+}
+
+//getDocument reads the document hash anchored to the record, nil if it has not been anchored
+func getDocument(ctx contractapi.TransactionContextInterface, recordType, recordId, hash string) (*DocumentRef, error) {
+	documentIndexKey, err := ctx.GetStub().CreateCompositeKey(documentIndexName, []string{recordType, recordId, hash})
+	if err != nil {
+		return nil, err
+	}
+	documentJSON, err := ctx.GetStub().GetState(documentIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if documentJSON == nil {
+		return nil, nil
+	}
+
+	var document DocumentRef
+	err = json.Unmarshal(documentJSON, &document)
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+//anchorDocument anchors the document to the record, the caller is recorded as uploader
+func anchorDocument(ctx contractapi.TransactionContextInterface, recordType, recordId, hash, mediaType string, size int64, uri string) error {
+	hash = strings.ToLower(hash)
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != 32 {
+		return fmt.Errorf("the hash %s is not a hex encoded sha256", hash)
+	}
+	if size < 0 {
+		return fmt.Errorf("the document size %d is negative", size)
+	}
+
+	anchored, err := getDocument(ctx, recordType, recordId, hash)
+	if err != nil {
+		return err
+	}
+	if anchored != nil {
+		return fmt.Errorf("the document %s has been anchored to %s %s at %s", hash, recordType, recordId, anchored.AnchorTime)
+	}
+
+	uploader, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's identity: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	document := DocumentRef{
+		RecordType: recordType,
+		RecordId:   recordId,
+		Hash:       hash,
+		MediaType:  mediaType,
+		Size:       size,
+		URI:        uri,
+		Uploader:   uploader,
+		AnchorTime: txTime.Format(time.RFC3339),
+		TxId:       ctx.GetStub().GetTxID(),
+	}
+	documentJSON, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	documentIndexKey, err := ctx.GetStub().CreateCompositeKey(documentIndexName, []string{recordType, recordId, hash})
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(documentIndexKey, documentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//AttachOrderDocument anchors a document to the order orderId
+func (s *SmartContract) AttachOrderDocument(ctx contractapi.TransactionContextInterface, orderId int, hash, mediaType string,
+	size int64, uri string) Result {
+	exists, err := s.OrderExists(ctx, orderId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if !exists {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the order %d does not exist", orderId),
+		}
+	}
+
+	err = anchorDocument(ctx, DocumentRecordOrder, strconv.Itoa(orderId), hash, mediaType, size, uri)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//AttachInspectionDocument anchors a document to the inspection checkIndex (the index of its result in the cargo) of the train's cargo
+func (s *SmartContract) AttachInspectionDocument(ctx contractapi.TransactionContextInterface, trainNumber string, checkIndex int,
+	hash, mediaType string, size int64, uri string) Result {
+	cargo, err := getCargo(ctx, trainNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if checkIndex < 0 || checkIndex >= len(cargo.StationCheckResult) {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the cargo %s has no inspection %d", trainNumber, checkIndex),
+		}
+	}
+
+	err = anchorDocument(ctx, DocumentRecordInspection, trainNumber+"/"+strconv.Itoa(checkIndex), hash, mediaType, size, uri)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//AttachWayBillDocument anchors a document to the stop location (the index of the station in the waybill) of the train
+func (s *SmartContract) AttachWayBillDocument(ctx contractapi.TransactionContextInterface, trainNumber string, location int,
+	hash, mediaType string, size int64, uri string) Result {
+	waybill, err := getWayBill(ctx, trainNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if location < 0 || location >= len(waybill.WayStation) {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the waybill %s has no stop %d", trainNumber, location),
+		}
+	}
+
+	err = anchorDocument(ctx, DocumentRecordWayBillStop, trainNumber+"/"+strconv.Itoa(location), hash, mediaType, size, uri)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//VerifyDocument confirms the document hash was anchored to the record no later than anchorTime (RFC3339),
+//any anchoring time is accepted if anchorTime is empty
+func (s *SmartContract) VerifyDocument(ctx contractapi.TransactionContextInterface, recordType, recordId, hash, anchorTime string) DocumentQueryResult {
+	document, err := getDocument(ctx, recordType, recordId, strings.ToLower(hash))
+	if err != nil {
+		return DocumentQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: DocumentRef{},
+		}
+	}
+	if document == nil {
+		return DocumentQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("the document %s is not anchored to %s %s", hash, recordType, recordId),
+			Data: DocumentRef{},
+		}
+	}
+
+	if anchorTime != "" {
+		verifyTime, err := time.Parse(time.RFC3339, anchorTime)
+		if err != nil {
+			return DocumentQueryResult{
+				Code: 402,
+				Msg:  fmt.Sprintf("anchorTime error: %v", err),
+				Data: DocumentRef{},
+			}
+		}
+		anchoredTime, err := time.Parse(time.RFC3339, document.AnchorTime)
+		if err != nil {
+			return DocumentQueryResult{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: DocumentRef{},
+			}
+		}
+		if anchoredTime.After(verifyTime) {
+			return DocumentQueryResult{
+				Code: 402,
+				Msg:  fmt.Sprintf("the document %s was anchored at %s, after %s", hash, document.AnchorTime, anchorTime),
+				Data: *document,
+			}
+		}
+	}
+
+	return DocumentQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *document,
+	}
+}
+
+//QueryDocumentsByrecord returns all documents anchored to the record
+func (s *SmartContract) QueryDocumentsByrecord(ctx contractapi.TransactionContextInterface, recordType, recordId string) DocumentQueryResults {
+	documentResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(documentIndexName, []string{recordType, recordId})
+	if err != nil {
+		return DocumentQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: DocumentRefs{DocumentsData: []DocumentRef{}},
+		}
+	}
+	defer documentResultsIterator.Close()
+
+	var documents []DocumentRef
+	for documentResultsIterator.HasNext() {
+		documentQueryResponse, err := documentResultsIterator.Next()
+		if err != nil {
+			return DocumentQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: DocumentRefs{DocumentsData: []DocumentRef{}},
+			}
+		}
+
+		var document DocumentRef
+		err = json.Unmarshal(documentQueryResponse.Value, &document)
+		if err != nil {
+			return DocumentQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: DocumentRefs{DocumentsData: []DocumentRef{}},
+			}
+		}
+		documents = append(documents, document)
+	}
+	if documents == nil {
+		return DocumentQueryResults{
+			Code: 402,
+			Msg:  "No document",
+			Data: DocumentRefs{DocumentsData: []DocumentRef{}},
+		}
+	}
+
+	return DocumentQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: DocumentRefs{DocumentsData: documents},
+	}
+}
@@ -0,0 +1,299 @@
+//@author: hdsfade
+//@date: 2021-02-03-10:12
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+var holdIndexName = "hold"
+
+//Hold describes orders of a train held after a failed cargo check
+type Hold struct { //扣留
+	TrainNumber string `json:"trainNumber"`
+	StationName string `json:"stationName"`
+	Location    int    `json:"location"`
+	WholeTrain  bool   `json:"wholeTrain"`
+	OrderIds    []int  `json:"orderIds"`
+	Reason      string `json:"reason"`
+	Active      bool   `json:"active"`
+}
+
+//HoldQueryResult structure used for handing result of query
+type HoldQueryResult struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Hold   `json:"data"`
+}
+
+//getHold reads the hold of the train trainNumber, nil if the train has never been held
+func getHold(ctx contractapi.TransactionContextInterface, trainNumber string) (*Hold, error) {
+	holdIndexKey, err := ctx.GetStub().CreateCompositeKey(holdIndexName, []string{trainNumber})
+	if err != nil {
+		return nil, err
+	}
+	holdJSON, err := ctx.GetStub().GetState(holdIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if holdJSON == nil {
+		return nil, nil
+	}
+
+	var hold Hold
+	err = json.Unmarshal(holdJSON, &hold)
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+//getActiveHold reads the hold of the train trainNumber, nil if the train is not on hold
+func getActiveHold(ctx contractapi.TransactionContextInterface, trainNumber string) (*Hold, error) {
+	hold, err := getHold(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	if hold == nil || !hold.Active {
+		return nil, nil
+	}
+	return hold, nil
+}
+
+//putHold writes the hold to the world state
+func putHold(ctx contractapi.TransactionContextInterface, hold *Hold) error {
+	holdIndexKey, err := ctx.GetStub().CreateCompositeKey(holdIndexName, []string{hold.TrainNumber})
+	if err != nil {
+		return err
+	}
+	holdJSON, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(holdIndexKey, holdJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//placeHold places orders orderIds of the train trainNumber on hold at its current station,
+//all orders of the train are held if orderIds is empty
+func placeHold(ctx contractapi.TransactionContextInterface, trainNumber string, orderIds []int, reason string) error {
+	waybill, err := getWayBill(ctx, trainNumber)
+	if err != nil {
+		return err
+	}
+	stationName := waybill.currentStation()
+
+	wholeTrain := len(orderIds) == 0
+	if wholeTrain {
+		orderIds, err = trainOrderIds(ctx, trainNumber)
+		if err != nil {
+			return err
+		}
+	}
+
+	hold, err := getActiveHold(ctx, trainNumber)
+	if err != nil {
+		return err
+	}
+	if hold == nil {
+		hold = &Hold{
+			TrainNumber: trainNumber,
+			StationName: stationName,
+			Location:    waybill.Location,
+			WholeTrain:  false,
+			OrderIds:    []int{},
+			Reason:      "",
+			Active:      true,
+		}
+	}
+	hold.WholeTrain = hold.WholeTrain || wholeTrain
+	if hold.Reason == "" {
+		hold.Reason = reason
+	} else {
+		hold.Reason += "; " + reason
+	}
+
+	//every order is checked before any is written
+	var orders []*Order
+	for _, orderId := range orderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return err
+		}
+		if order.TrainNumber != trainNumber {
+			return fmt.Errorf("the order %d is not on the train %s", orderId, trainNumber)
+		}
+		if order.State == OrderStateOffloaded {
+			if wholeTrain {
+				continue
+			}
+			return fmt.Errorf("the order %d has been offloaded at station %s", orderId, order.OffloadStation)
+		}
+		if order.State == OrderStateDelivered || order.State == OrderStateClosed {
+			if wholeTrain {
+				continue
+			}
+			return fmt.Errorf("the order %d has been delivered at station %s", orderId, order.DestinationStation)
+		}
+		orders = append(orders, order)
+	}
+
+	for _, order := range orders {
+		if order.State != OrderStateOnHold {
+			order.StateBeforeHold = order.State
+			order.State = OrderStateOnHold
+			err = putOrder(ctx, order)
+			if err != nil {
+				return err
+			}
+		}
+		if !containsInt(hold.OrderIds, order.OrderId) {
+			hold.OrderIds = append(hold.OrderIds, order.OrderId)
+		}
+	}
+
+	return putHold(ctx, hold)
+}
+
+//ReleaseHold releases orders orderIds of the train trainNumber from hold, all held orders are released if orderIds is empty.
+//Every order is checked before any is written, and a failure is returned as an error.
+func (s *SmartContract) ReleaseHold(ctx contractapi.TransactionContextInterface, trainNumber string, orderIds []int) (Result, error) {
+	hold, err := getActiveHold(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if hold == nil {
+		return failure(fmt.Errorf("the train %s is not on hold", trainNumber))
+	}
+
+	if len(orderIds) == 0 {
+		orderIds = append([]int{}, hold.OrderIds...)
+	}
+	var orders []*Order
+	for i, orderId := range orderIds {
+		if !containsInt(hold.OrderIds, orderId) {
+			return failure(fmt.Errorf("the order %d is not held on the train %s", orderId, trainNumber))
+		}
+		if containsInt(orderIds[:i], orderId) {
+			continue
+		}
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return failure(err)
+		}
+		orders = append(orders, order)
+	}
+
+	for _, order := range orders {
+		//the order goes back to the state it was held in
+		order.State = order.StateBeforeHold
+		if order.State == "" {
+			order.State = OrderStateBooked
+		}
+		order.StateBeforeHold = ""
+		err = putOrder(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+		hold.OrderIds = removeInt(hold.OrderIds, order.OrderId)
+	}
+
+	//the hold ends when no order is held any more
+	if len(hold.OrderIds) == 0 {
+		hold.Active = false
+	}
+	err = putHold(ctx, hold)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//OffloadOrder offloads the order orderId at the train's current station and releases its carriages,
+//a failure is returned as an error so that no write of the transaction is committed
+func (s *SmartContract) OffloadOrder(ctx contractapi.TransactionContextInterface, orderId int) (Result, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if order.State == OrderStateOffloaded {
+		return failure(fmt.Errorf("the order %d has been offloaded at station %s", orderId, order.OffloadStation))
+	}
+	if order.State == OrderStateDelivered || order.State == OrderStateClosed {
+		return failure(fmt.Errorf("the order %d has been delivered at station %s", orderId, order.DestinationStation))
+	}
+
+	waybill, err := getWayBill(ctx, order.TrainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if waybill.Location < 0 || waybill.Location >= len(waybill.WayStation) {
+		return failure(fmt.Errorf("the train %s's location %d is out of its line", order.TrainNumber, waybill.Location))
+	}
+	hold, err := getActiveHold(ctx, order.TrainNumber)
+	if err != nil {
+		return failure(err)
+	}
+
+	//the carriages are free for the rest of the line
+	err = releaseCarriages(ctx, order.TrainNumber, order.Carriages, orderId, "")
+	if err != nil {
+		return failure(err)
+	}
+
+	order.State = OrderStateOffloaded
+	order.StateBeforeHold = ""
+	order.OffloadStation = waybill.WayStation[waybill.Location]
+	err = putOrder(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+
+	if hold != nil && containsInt(hold.OrderIds, orderId) {
+		hold.OrderIds = removeInt(hold.OrderIds, orderId)
+		if len(hold.OrderIds) == 0 {
+			hold.Active = false
+		}
+		err = putHold(ctx, hold)
+		if err != nil {
+			return failure(err)
+		}
+	}
+
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryHoldBytrainnumber returns the hold of the train in the world state with given trainNumber
+func (s *SmartContract) QueryHoldBytrainnumber(ctx contractapi.TransactionContextInterface, trainNumber string) HoldQueryResult {
+	hold, err := getHold(ctx, trainNumber)
+	if err != nil {
+		return HoldQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Hold{OrderIds: []int{}},
+		}
+	}
+	if hold == nil {
+		return HoldQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("the train %s has never been held", trainNumber),
+			Data: Hold{OrderIds: []int{}},
+		}
+	}
+	return HoldQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *hold,
+	}
+}
@@ -0,0 +1,531 @@
+//@author: hdsfade
+//@date: 2021-02-13-09:48
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
+)
+
+var invoiceIndexName = "invoice"
+var customerinvoiceIndexName = "customer~invoice"
+
+//invoice states
+const (
+	InvoiceStatusIssued        = "issued"        //已开票
+	InvoiceStatusPartiallyPaid = "partiallypaid" //部分付款
+	InvoiceStatusPaid          = "paid"          //已付款
+)
+
+//InvoiceLineItem describes an order billed by an invoice
+type InvoiceLineItem struct {
+	OrderId     int    `json:"orderId"`
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+//Payment describes a payment of an invoice
+type Payment struct {
+	Amount    int64  `json:"amount"`
+	Reference string `json:"reference"`
+	PaidTime  string `json:"paidTime"`
+	TxId      string `json:"txId"`
+}
+
+//Invoice describes details of an invoice, it is kept in the private data collection of its customer.
+//All amounts are in minor units of the invoice's currency.
+type Invoice struct { //发票
+	InvoiceId  string            `json:"invoiceId"`
+	CustomerId int               `json:"customerId"`
+	Currency   string            `json:"currency"`
+	LineItems  []InvoiceLineItem `json:"lineItems"`
+	Subtotal   int64             `json:"subtotal"`
+	TaxRate    int               `json:"taxRate"` //percent
+	Tax        int64             `json:"tax"`
+	Total      int64             `json:"total"`
+	IssueDate  string            `json:"issueDate"`
+	DueDate    string            `json:"dueDate"`
+	Payments   []Payment         `json:"payments"`
+	PaidAmount int64             `json:"paidAmount"`
+	Status     string            `json:"status"`
+	//the invoice is final once all its orders are delivered
+	FinalizeDate string `json:"finalizeDate,omitempty" metadata:",optional"`
+}
+
+//CustomerBalance describes the amounts a customer owes in minor units of its billing currency
+type CustomerBalance struct {
+	CustomerId  int    `json:"customerId"`
+	Currency    string `json:"currency"`
+	Reserved    int64  `json:"reserved"`    //price of active reservations
+	Uninvoiced  int64  `json:"uninvoiced"`  //price of orders not invoiced yet
+	Invoiced    int64  `json:"invoiced"`    //total of all invoices
+	Paid        int64  `json:"paid"`        //payments of all invoices
+	Outstanding int64  `json:"outstanding"` //invoiced but not paid
+}
+
+//InvoiceQueryResult structure used for handing result of query
+type InvoiceQueryResult struct {
+	Code int     `json:"code"`
+	Msg  string  `json:"msg"`
+	Data Invoice `json:"data"`
+}
+
+//CustomerBalanceQueryResult structure used for handing result of query balance
+type CustomerBalanceQueryResult struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data CustomerBalance `json:"data"`
+}
+
+//invoiceExists judges an invoice if exists in the private data collection of the customer or not
+func invoiceExists(ctx contractapi.TransactionContextInterface, customer *Customer, invoiceId string) (bool, error) {
+	invoiceIndexKey, err := ctx.GetStub().CreateCompositeKey(invoiceIndexName, []string{invoiceId})
+	if err != nil {
+		return false, err
+	}
+	invoiceJSON, err := ctx.GetStub().GetPrivateData(orderCollectionName(customer.MSPID), invoiceIndexKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from private data collection %v", err)
+	}
+	return invoiceJSON != nil, nil
+}
+
+//getInvoice reads the invoice from the private data collection of the customer
+func getInvoice(ctx contractapi.TransactionContextInterface, customer *Customer, invoiceId string) (*Invoice, error) {
+	invoiceIndexKey, err := ctx.GetStub().CreateCompositeKey(invoiceIndexName, []string{invoiceId})
+	if err != nil {
+		return nil, err
+	}
+	invoiceJSON, err := ctx.GetStub().GetPrivateData(orderCollectionName(customer.MSPID), invoiceIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %v", err)
+	}
+	if invoiceJSON == nil {
+		return nil, fmt.Errorf("the invoice %s of customer %d does not exist", invoiceId, customer.CustomerId)
+	}
+
+	var invoice Invoice
+	err = json.Unmarshal(invoiceJSON, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.CustomerId != customer.CustomerId {
+		return nil, fmt.Errorf("the invoice %s doesn't belong to customer %d", invoiceId, customer.CustomerId)
+	}
+	return &invoice, nil
+}
+
+//putInvoice writes the invoice to the private data collection of the customer
+func putInvoice(ctx contractapi.TransactionContextInterface, customer *Customer, invoice *Invoice) error {
+	invoiceIndexKey, err := ctx.GetStub().CreateCompositeKey(invoiceIndexName, []string{invoice.InvoiceId})
+	if err != nil {
+		return err
+	}
+	invoiceJSON, err := json.Marshal(invoice)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(orderCollectionName(customer.MSPID), invoiceIndexKey, invoiceJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to private data collection. %v", err)
+	}
+	return nil
+}
+
+//customerInvoices returns all invoices of the customer
+func customerInvoices(ctx contractapi.TransactionContextInterface, customer *Customer) ([]*Invoice, error) {
+	invoiceResultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(
+		orderCollectionName(customer.MSPID), customerinvoiceIndexName, []string{strconv.Itoa(customer.CustomerId)})
+	if err != nil {
+		return nil, err
+	}
+	defer invoiceResultsIterator.Close()
+
+	var invoices []*Invoice
+	for invoiceResultsIterator.HasNext() {
+		invoiceQueryResponse, err := invoiceResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		invoice, err := getInvoice(ctx, customer, string(invoiceQueryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, nil
+}
+
+//customerBalance sums up reservations, orders and invoices of the customer in its billing currency,
+//amounts in other currencies are converted by the exchange rates effective at the transaction time
+func customerBalance(ctx contractapi.TransactionContextInterface, customer *Customer) (*CustomerBalance, error) {
+	balance := CustomerBalance{CustomerId: customer.CustomerId, Currency: customer.BillingCurrency}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orderIds, err := customerOrderIds(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	for _, orderId := range orderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		//an order offloaded before it was invoiced is never charged
+		if order.InvoiceId != "" || order.State == OrderStateOffloaded {
+			continue
+		}
+		details, err := getOrderPrivateDetails(ctx, order)
+		if err != nil {
+			return nil, err
+		}
+		price, err := convertMoney(ctx, details.Price, customer.BillingCurrency, txTime)
+		if err != nil {
+			return nil, err
+		}
+		balance.Uninvoiced += price.Amount
+	}
+
+	//an active reservation holds carriages at its quoted price until it is confirmed or expires
+	reservations, err := customerReservations(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range reservations {
+		if reservation.State != ReservationStateActive {
+			continue
+		}
+		expired, err := reservationExpired(reservation, txTime)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			continue
+		}
+		details, err := getReservationPrivateDetails(ctx, reservation)
+		if err != nil {
+			return nil, err
+		}
+		price, err := convertMoney(ctx, details.Price, customer.BillingCurrency, txTime)
+		if err != nil {
+			return nil, err
+		}
+		balance.Reserved += price.Amount
+	}
+
+	invoices, err := customerInvoices(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	for _, invoice := range invoices {
+		total, err := convertMoney(ctx, Money{Currency: invoice.Currency, Amount: invoice.Total}, customer.BillingCurrency, txTime)
+		if err != nil {
+			return nil, err
+		}
+		paid, err := convertMoney(ctx, Money{Currency: invoice.Currency, Amount: invoice.PaidAmount}, customer.BillingCurrency, txTime)
+		if err != nil {
+			return nil, err
+		}
+		balance.Invoiced += total.Amount
+		balance.Paid += paid.Amount
+	}
+	balance.Outstanding = balance.Invoiced - balance.Paid
+	return &balance, nil
+}
+
+//CreateInvoice issues an invoice of the customer's orders orderIds, only the operator could issue invoices,
+//and an order offloaded before it is invoiced is never charged.
+//Prices of orders are converted into currency by the exchange rates effective at the transaction time,
+//taxRate is in percent and dueDate is formatted as 2006-01-02. A failure is returned as an error
+//so that no write of the transaction is committed.
+func (s *SmartContract) CreateInvoice(ctx contractapi.TransactionContextInterface, invoiceId string, customerId int, orderIds []int,
+	currency string, taxRate int, dueDate string) (Result, error) {
+	err := assertOperator(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	if len(orderIds) == 0 {
+		return failure(fmt.Errorf("the invoice %s has no order", invoiceId))
+	}
+	//the transaction doesn't read its own writes, so an order given twice would be billed twice
+	for i, orderId := range orderIds {
+		if containsInt(orderIds[:i], orderId) {
+			return failure(fmt.Errorf("the order %d is given twice", orderId))
+		}
+	}
+	if taxRate < 0 {
+		return failure(fmt.Errorf("the tax rate %d must not be negative", taxRate))
+	}
+	err = checkCurrency(currency)
+	if err != nil {
+		return failure(err)
+	}
+	_, err = time.Parse("2006-01-02", dueDate)
+	if err != nil {
+		return failure(fmt.Errorf("dueDate error: %v", err))
+	}
+
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return failure(err)
+	}
+	exists, err := invoiceExists(ctx, customer, invoiceId)
+	if err != nil {
+		return failure(err)
+	}
+	if exists {
+		return failure(fmt.Errorf("the invoice %s already exists", invoiceId))
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	invoice := Invoice{
+		InvoiceId:  invoiceId,
+		CustomerId: customerId,
+		Currency:   currency,
+		LineItems:  []InvoiceLineItem{},
+		Subtotal:   0,
+		TaxRate:    taxRate,
+		Tax:        0,
+		Total:      0,
+		IssueDate:  txTime.Format("2006-01-02"),
+		DueDate:    dueDate,
+		Payments:   []Payment{},
+		PaidAmount: 0,
+		Status:     InvoiceStatusIssued,
+	}
+
+	//every order is checked before any is written
+	var orders []*Order
+	for _, orderId := range orderIds {
+		order, err := getOrder(ctx, orderId)
+		if err != nil {
+			return failure(err)
+		}
+		if order.InvoiceId != "" {
+			return failure(fmt.Errorf("the order %d has been invoiced by %s", orderId, order.InvoiceId))
+		}
+		if order.State == OrderStateOffloaded {
+			return failure(fmt.Errorf("the order %d has been offloaded at station %s before it was invoiced", orderId, order.OffloadStation))
+		}
+		details, err := getOrderPrivateDetails(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+		if details.CustomerId != customerId {
+			return failure(fmt.Errorf("the order %d doesn't belong to customer %d", orderId, customerId))
+		}
+		price, err := convertMoney(ctx, details.Price, currency, txTime)
+		if err != nil {
+			return failure(err)
+		}
+		invoice.LineItems = append(invoice.LineItems, InvoiceLineItem{
+			OrderId:     orderId,
+			Description: fmt.Sprintf("train %s %s-%s, %d carriages", order.TrainNumber, order.StartingStation, order.DestinationStation, order.CarriageNumber),
+			Amount:      price.Amount,
+		})
+		invoice.Subtotal += price.Amount
+		order.InvoiceId = invoiceId
+		order.PaymentState = PaymentStateInvoiced
+		orders = append(orders, order)
+	}
+	//tax is rounded half up
+	invoice.Tax = (invoice.Subtotal*int64(taxRate) + 50) / 100
+	invoice.Total = invoice.Subtotal + invoice.Tax
+
+	for _, order := range orders {
+		err = putOrder(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+	}
+	err = putInvoice(ctx, customer, &invoice)
+	if err != nil {
+		return failure(err)
+	}
+	customerinvoiceIndexKey, err := ctx.GetStub().CreateCompositeKey(customerinvoiceIndexName, []string{strconv.Itoa(customerId), invoiceId})
+	if err != nil {
+		return failure(err)
+	}
+	err = ctx.GetStub().PutPrivateData(orderCollectionName(customer.MSPID), customerinvoiceIndexKey, []byte(invoiceId))
+	if err != nil {
+		return failure(err)
+	}
+
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//RecordPayment records a (partial) payment of amount (in minor units of the invoice's currency) of the invoice,
+//orders of a fully paid invoice are paid
+func (s *SmartContract) RecordPayment(ctx contractapi.TransactionContextInterface, customerId int, invoiceId string, amount int64,
+	reference string) (Result, error) {
+	err := assertOperator(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return failure(err)
+	}
+	invoice, err := getInvoice(ctx, customer, invoiceId)
+	if err != nil {
+		return failure(err)
+	}
+	if amount <= 0 || amount > invoice.Total-invoice.PaidAmount {
+		return failure(fmt.Errorf("the payment %d must be positive and no more than the invoice %s's balance %d", amount, invoiceId, invoice.Total-invoice.PaidAmount))
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	invoice.Payments = append(invoice.Payments, Payment{
+		Amount:    amount,
+		Reference: reference,
+		PaidTime:  txTime.Format(time.RFC3339),
+		TxId:      ctx.GetStub().GetTxID(),
+	})
+	invoice.PaidAmount += amount
+	invoice.Status = InvoiceStatusPartiallyPaid
+	if invoice.PaidAmount == invoice.Total {
+		invoice.Status = InvoiceStatusPaid
+		for _, item := range invoice.LineItems {
+			order, err := getOrder(ctx, item.OrderId)
+			if err != nil {
+				return failure(err)
+			}
+			order.PaymentState = PaymentStatePaid
+			err = putOrder(ctx, order)
+			if err != nil {
+				return failure(err)
+			}
+		}
+	}
+
+	err = putInvoice(ctx, customer, invoice)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//FinalizeInvoice finalizes the invoice once every order of it is delivered to its consignee or offloaded,
+//only the operator could finalize invoices. Delivered orders of the invoice are closed.
+func (s *SmartContract) FinalizeInvoice(ctx contractapi.TransactionContextInterface, customerId int, invoiceId string) (Result, error) {
+	err := assertOperator(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return failure(err)
+	}
+	invoice, err := getInvoice(ctx, customer, invoiceId)
+	if err != nil {
+		return failure(err)
+	}
+	if invoice.FinalizeDate != "" {
+		return failure(fmt.Errorf("the invoice %s has been finalized on %s", invoiceId, invoice.FinalizeDate))
+	}
+
+	//every order is checked before any is written
+	var orders []*Order
+	for _, item := range invoice.LineItems {
+		order, err := getOrder(ctx, item.OrderId)
+		if err != nil {
+			return failure(err)
+		}
+		if order.State != OrderStateDelivered && order.State != OrderStateOffloaded {
+			return failure(fmt.Errorf("the order %d of the invoice %s is %s, not delivered", item.OrderId, invoiceId, order.State))
+		}
+		orders = append(orders, order)
+	}
+	for _, order := range orders {
+		if order.State != OrderStateDelivered {
+			continue
+		}
+		order.State = OrderStateClosed
+		err = putOrder(ctx, order)
+		if err != nil {
+			return failure(err)
+		}
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	invoice.FinalizeDate = txTime.Format("2006-01-02")
+	err = putInvoice(ctx, customer, invoice)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryInvoiceByinvoiceid returns the invoice of the customer with given invoiceId
+func (s *SmartContract) QueryInvoiceByinvoiceid(ctx contractapi.TransactionContextInterface, customerId int, invoiceId string) InvoiceQueryResult {
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return InvoiceQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Invoice{LineItems: []InvoiceLineItem{}, Payments: []Payment{}},
+		}
+	}
+	invoice, err := getInvoice(ctx, customer, invoiceId)
+	if err != nil {
+		return InvoiceQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Invoice{LineItems: []InvoiceLineItem{}, Payments: []Payment{}},
+		}
+	}
+	return InvoiceQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *invoice,
+	}
+}
+
+//QueryCustomerBalance returns the reserved, uninvoiced, invoiced, paid and outstanding amounts of the customer
+func (s *SmartContract) QueryCustomerBalance(ctx contractapi.TransactionContextInterface, customerId int) CustomerBalanceQueryResult {
+	customer, err := getCustomer(ctx, customerId)
+	if err != nil {
+		return CustomerBalanceQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: CustomerBalance{},
+		}
+	}
+	balance, err := customerBalance(ctx, customer)
+	if err != nil {
+		return CustomerBalanceQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: CustomerBalance{},
+		}
+	}
+	return CustomerBalanceQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *balance,
+	}
+}
@@ -9,12 +9,22 @@ import (
 	"strconv"
 )
 
-//Line describes details of a line
+//LineSegment describes the track between two consecutive way stations of a line
+type LineSegment struct { //区段
+	FromStation string `json:"fromStation"`
+	ToStation   string `json:"toStation"`
+	Distance    int    `json:"distance"`    //km
+	RunningTime int    `json:"runningTime"` //nominal running time in minutes
+	Gauge       int    `json:"gauge"`       //track gauge in mm
+}
+
+//Line describes details of a line, Segments[i] is the track from WayStation[i] to WayStation[i+1]
 type Line struct {
-	LineNumber     int      `json:"lineNumber"`
-	WayStation     []string `json:"wayStation"`
-	WayStationType []string `json:"wayStationType"`
-	Using          bool     `json:"using"`
+	LineNumber     int           `json:"lineNumber"`
+	WayStation     []string      `json:"wayStation"`
+	WayStationType []string      `json:"wayStationType"`
+	Segments       []LineSegment `json:"segments,omitempty" metadata:",optional"`
+	Using          bool          `json:"using"`
 }
 
 type Lines struct {
@@ -50,8 +60,63 @@ func (s *SmartContract) LineExists(ctx contractapi.TransactionContextInterface,
 	return lineJSON != nil, nil
 }
 
-//CreateLine issues a new line to the world state with given details.
-func (s *SmartContract) CreateLine(ctx contractapi.TransactionContextInterface, lineNumber int, wayStation, wayStationType []string) Result {
+//getLine reads the line lineNumber from the world state
+func getLine(ctx contractapi.TransactionContextInterface, lineNumber int) (*Line, error) {
+	lineIndexKey, err := ctx.GetStub().CreateCompositeKey(lineIndexName, []string{strconv.Itoa(lineNumber)})
+	if err != nil {
+		return nil, err
+	}
+	lineJSON, err := ctx.GetStub().GetState(lineIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if lineJSON == nil {
+		return nil, fmt.Errorf("the line %d does not exist", lineNumber)
+	}
+
+	var line Line
+	err = json.Unmarshal(lineJSON, &line)
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+//stationIndex returns the index of the station in the line, -1 if the line doesn't pass the station
+func (line *Line) stationIndex(stationName string) int {
+	for i, wayStation := range line.WayStation {
+		if wayStation == stationName {
+			return i
+		}
+	}
+	return -1
+}
+
+//checkLineSegments judges the segments if describe every pair of consecutive way stations in order or not
+func checkLineSegments(wayStation []string, segments []LineSegment) error {
+	if len(wayStation) < 2 {
+		return fmt.Errorf("the line has %d way stations, less than 2", len(wayStation))
+	}
+	if len(segments) != len(wayStation)-1 {
+		return fmt.Errorf("the line has %d way stations but %d segments", len(wayStation), len(segments))
+	}
+	for i, segment := range segments {
+		if segment.FromStation != wayStation[i] || segment.ToStation != wayStation[i+1] {
+			return fmt.Errorf("the segment %d from %s to %s doesn't match the way stations %s and %s",
+				i, segment.FromStation, segment.ToStation, wayStation[i], wayStation[i+1])
+		}
+		if segment.Distance <= 0 || segment.RunningTime <= 0 || segment.Gauge <= 0 {
+			return fmt.Errorf("the segment from %s to %s must have positive distance, running time and gauge",
+				segment.FromStation, segment.ToStation)
+		}
+	}
+	return nil
+}
+
+//CreateLine issues a new line to the world state with given details,
+//segments give the distance, running time and gauge between every pair of consecutive way stations.
+func (s *SmartContract) CreateLine(ctx contractapi.TransactionContextInterface, lineNumber int, wayStation, wayStationType []string,
+	segments []LineSegment) Result {
 	lineIndexKey, err := ctx.GetStub().CreateCompositeKey(lineIndexName, []string{strconv.Itoa(lineNumber)})
 	if err != nil {
 		return Result{
@@ -90,10 +155,19 @@ func (s *SmartContract) CreateLine(ctx contractapi.TransactionContextInterface,
 		}
 	}
 
+	err = checkLineSegments(wayStation, segments)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
 	line := Line{
 		LineNumber:     lineNumber,
 		WayStation:     wayStation,
 		WayStationType: wayStationType,
+		Segments:       segments,
 		Using:          true,
 	}
 	lineJSON, err := json.Marshal(line)
@@ -0,0 +1,234 @@
+//@author: hdsfade
+//@date: 2021-02-23-10:30
+package chaincode
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"time"
+)
+
+//dateLayout is the layout of dates of maintenance windows and records
+var dateLayout = "2006-01-02"
+
+//MaintenanceWindow describes a planned period the vehicle is out of service, from FromDate to ToDate inclusive
+type MaintenanceWindow struct { //检修计划
+	FromDate string `json:"fromDate"`
+	ToDate   string `json:"toDate"`
+	Reason   string `json:"reason"`
+}
+
+//MaintenanceRecord describes maintenance performed on the vehicle
+type MaintenanceRecord struct { //检修记录
+	Date        string `json:"date"`
+	Description string `json:"description"`
+	Recorder    string `json:"recorder"` //MSPID of the organization recording the maintenance
+	RecordTime  string `json:"recordTime"`
+}
+
+//parseDateRange parses the dates fromDate and toDate, fromDate must not be after toDate
+func parseDateRange(fromDate, toDate string) (time.Time, time.Time, error) {
+	from, err := time.Parse(dateLayout, fromDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("date error: %v", err)
+	}
+	to, err := time.Parse(dateLayout, toDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("date error: %v", err)
+	}
+	if from.After(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("the date %s is after %s", fromDate, toDate)
+	}
+	return from, to, nil
+}
+
+//maintenanceWindow returns the first maintenance window of the vehicle overlapping the dates from from to to, nil if there is none
+func (vehicle *Vehicle) maintenanceWindow(from, to time.Time) *MaintenanceWindow {
+	fromDate, toDate := from.Format(dateLayout), to.Format(dateLayout)
+	for i := range vehicle.MaintenanceWindows {
+		window := &vehicle.MaintenanceWindows[i]
+		if window.FromDate <= toDate && fromDate <= window.ToDate {
+			return window
+		}
+	}
+	return nil
+}
+
+//checkVehicleAvailable judges the vehicle if could run from the date from to the date to or not,
+//the vehicle must be in service and out of its maintenance windows
+func checkVehicleAvailable(ctx contractapi.TransactionContextInterface, vehicleNumber int, from, to time.Time) error {
+	err := checkVehicleInService(ctx, vehicleNumber)
+	if err != nil {
+		return err
+	}
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return err
+	}
+	window := vehicle.maintenanceWindow(from, to)
+	if window != nil {
+		return fmt.Errorf("the vehicle %d is out of service from %s to %s: %s",
+			vehicleNumber, window.FromDate, window.ToDate, window.Reason)
+	}
+	return nil
+}
+
+//SetVehicleOutOfService plans a maintenance window of the vehicle from fromDate to toDate (formatted as 2006-01-02),
+//the window must not overlap other windows of the vehicle or trains the vehicle is assigned to, only by the operator
+func (s *SmartContract) SetVehicleOutOfService(ctx contractapi.TransactionContextInterface, vehicleNumber int,
+	fromDate, toDate, reason string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	from, to, err := parseDateRange(fromDate, toDate)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	window := vehicle.maintenanceWindow(from, to)
+	if window != nil {
+		return Result{
+			Code: 402,
+			Msg: fmt.Sprintf("the vehicle %d is already out of service from %s to %s",
+				vehicleNumber, window.FromDate, window.ToDate),
+		}
+	}
+	err = checkVehicleUnassigned(ctx, vehicleNumber, from, to, "")
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	vehicle.MaintenanceWindows = append(vehicle.MaintenanceWindows, MaintenanceWindow{
+		FromDate: fromDate,
+		ToDate:   toDate,
+		Reason:   reason,
+	})
+	err = putVehicle(ctx, vehicle)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//CancelVehicleOutOfService cancels the maintenance window of the vehicle starting from fromDate, only by the operator
+func (s *SmartContract) CancelVehicleOutOfService(ctx contractapi.TransactionContextInterface, vehicleNumber int, fromDate string) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	location := -1
+	for i, window := range vehicle.MaintenanceWindows {
+		if window.FromDate == fromDate {
+			location = i
+			break
+		}
+	}
+	if location < 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the vehicle %d has no maintenance window from %s", vehicleNumber, fromDate),
+		}
+	}
+
+	vehicle.MaintenanceWindows = append(vehicle.MaintenanceWindows[:location], vehicle.MaintenanceWindows[location+1:]...)
+	err = putVehicle(ctx, vehicle)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//RecordVehicleMaintenance records maintenance performed on the vehicle on the date (formatted as 2006-01-02)
+func (s *SmartContract) RecordVehicleMaintenance(ctx contractapi.TransactionContextInterface, vehicleNumber int,
+	date, description string) Result {
+	_, _, err := parseDateRange(date, date)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if description == "" {
+		return Result{
+			Code: 402,
+			Msg:  "the maintenance description is empty",
+		}
+	}
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("failed to get client's MSPID: %v", err),
+		}
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	vehicle.MaintenanceRecords = append(vehicle.MaintenanceRecords, MaintenanceRecord{
+		Date:        date,
+		Description: description,
+		Recorder:    mspId,
+		RecordTime:  txTime.Format(time.RFC3339),
+	})
+	err = putVehicle(ctx, vehicle)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
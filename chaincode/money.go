@@ -0,0 +1,304 @@
+//@author: hdsfade
+//@date: 2021-02-16-10:30
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exchangerateIndexName = "exchangerate"
+
+//exchangeRateRole is the value of the role attribute of identities allowed to set exchange rates
+var exchangeRateRole = "rateadmin"
+
+//currencyMinorUnits is the number of minor unit digits of supported currencies
+var currencyMinorUnits = map[string]int{
+	"CNY": 2,
+	"EUR": 2,
+	"USD": 2,
+}
+
+//rateScale is the scale of exchange rates, a rate of 7.123456 is stored as 7123456
+var rateScale int64 = 1000000
+
+//rateTimeLayout formats effective times so that rates of a currency pair are iterated in order
+var rateTimeLayout = "2006-01-02T15:04:05Z"
+
+//Money describes an amount of a currency in minor units, e.g. EUR 12.34 is {"EUR", 1234}
+type Money struct {
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+}
+
+//legacyCurrency is the currency of prices stored as plain numbers before amounts had a currency
+var legacyCurrency = "CNY"
+
+//UnmarshalJSON reads a money, or a plain number stored before amounts had a currency,
+//e.g. the unitPrice 1000 of an old schedule, as whole units of legacyCurrency
+func (money *Money) UnmarshalJSON(data []byte) error {
+	var units int64
+	if json.Unmarshal(data, &units) == nil {
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currencyMinorUnits[legacyCurrency])), nil)
+		money.Currency = legacyCurrency
+		money.Amount = units * scale.Int64()
+		return nil
+	}
+	//moneyFields has the fields of Money but not its UnmarshalJSON
+	type moneyFields Money
+	return json.Unmarshal(data, (*moneyFields)(money))
+}
+
+//ExchangeRate describes the rate from a currency to another since its effective time
+type ExchangeRate struct { //汇率
+	FromCurrency  string `json:"fromCurrency"`
+	ToCurrency    string `json:"toCurrency"`
+	Rate          int64  `json:"rate"` //units of ToCurrency per unit of FromCurrency, scaled by rateScale
+	EffectiveFrom string `json:"effectiveFrom"`
+	SetBy         string `json:"setBy"`
+}
+
+//ExchangeRateQueryResult structure used for handing result of query
+type ExchangeRateQueryResult struct {
+	Code int          `json:"code"`
+	Msg  string       `json:"msg"`
+	Data ExchangeRate `json:"data"`
+}
+
+//checkCurrency judges a currency if supported or not
+func checkCurrency(currency string) error {
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return fmt.Errorf("the currency %s is not supported", currency)
+	}
+	return nil
+}
+
+//parseRate parses a decimal rate such as "7.123456" into a scaled rate
+func parseRate(rate string) (int64, error) {
+	parts := strings.SplitN(rate, ".", 2)
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > 6 {
+		return 0, fmt.Errorf("the rate %s has more than 6 decimals", rate)
+	}
+	scaled, err := strconv.ParseInt(parts[0]+fraction+strings.Repeat("0", 6-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("rate error: %v", err)
+	}
+	if scaled <= 0 {
+		return 0, fmt.Errorf("the rate %s must be positive", rate)
+	}
+	return scaled, nil
+}
+
+//convertAmount converts amount (minor units of from) by the scaled rate into minor units of to, rounded half up.
+//The rate is the one from to to from if inverse is true.
+func convertAmount(amount int64, from, to string, rate int64, inverse bool) int64 {
+	numerator := big.NewInt(amount)
+	numerator.Mul(numerator, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currencyMinorUnits[to])), nil))
+	denominator := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(currencyMinorUnits[from])), nil)
+	if inverse {
+		numerator.Mul(numerator, big.NewInt(rateScale))
+		denominator.Mul(denominator, big.NewInt(rate))
+	} else {
+		numerator.Mul(numerator, big.NewInt(rate))
+		denominator.Mul(denominator, big.NewInt(rateScale))
+	}
+
+	//(2 * numerator + denominator) / (2 * denominator) rounds half up
+	numerator.Mul(numerator, big.NewInt(2))
+	numerator.Add(numerator, denominator)
+	denominator.Mul(denominator, big.NewInt(2))
+	return numerator.Div(numerator, denominator).Int64()
+}
+
+//getExchangeRate returns the rate from a currency to another effective at the time, nil if there is none
+func getExchangeRate(ctx contractapi.TransactionContextInterface, fromCurrency, toCurrency string, at time.Time) (*ExchangeRate, error) {
+	rateResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(exchangerateIndexName, []string{fromCurrency, toCurrency})
+	if err != nil {
+		return nil, err
+	}
+	defer rateResultsIterator.Close()
+
+	//rates are iterated by effective time, the last one effective at the time is used
+	atTime := at.UTC().Format(rateTimeLayout)
+	var effective *ExchangeRate
+	for rateResultsIterator.HasNext() {
+		rateQueryResponse, err := rateResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var rate ExchangeRate
+		err = json.Unmarshal(rateQueryResponse.Value, &rate)
+		if err != nil {
+			return nil, err
+		}
+		if rate.EffectiveFrom > atTime {
+			break
+		}
+		effective = &rate
+	}
+	return effective, nil
+}
+
+//convertMoney converts money into the currency by the rate effective at the time,
+//the inverse rate is used if there is no rate from the money's currency to the currency
+func convertMoney(ctx contractapi.TransactionContextInterface, money Money, currency string, at time.Time) (Money, error) {
+	if money.Currency == currency {
+		return money, nil
+	}
+	err := checkCurrency(money.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	err = checkCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	rate, err := getExchangeRate(ctx, money.Currency, currency, at)
+	if err != nil {
+		return Money{}, err
+	}
+	if rate != nil {
+		return Money{Currency: currency, Amount: convertAmount(money.Amount, money.Currency, currency, rate.Rate, false)}, nil
+	}
+	rate, err = getExchangeRate(ctx, currency, money.Currency, at)
+	if err != nil {
+		return Money{}, err
+	}
+	if rate != nil {
+		return Money{Currency: currency, Amount: convertAmount(money.Amount, money.Currency, currency, rate.Rate, true)}, nil
+	}
+	return Money{}, fmt.Errorf("no exchange rate from %s to %s is effective at %s", money.Currency, currency, at.Format(time.RFC3339))
+}
+
+//SetExchangeRate sets the rate (a decimal such as "7.123456") from a currency to another effective from effectiveFrom (RFC3339),
+//only identities with the role attribute rateadmin could set exchange rates
+func (s *SmartContract) SetExchangeRate(ctx contractapi.TransactionContextInterface, fromCurrency, toCurrency, rate, effectiveFrom string) Result {
+	err := assertRole(ctx, exchangeRateRole)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkCurrency(fromCurrency)
+	if err == nil {
+		err = checkCurrency(toCurrency)
+	}
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if fromCurrency == toCurrency {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the exchange rate from %s to itself couldn't be set", fromCurrency),
+		}
+	}
+	scaledRate, err := parseRate(rate)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	effectiveTime, err := time.Parse(time.RFC3339, effectiveFrom)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("effectiveFrom error: %v", err),
+		}
+	}
+	setBy, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("failed to get client's identity: %v", err),
+		}
+	}
+
+	exchangeRate := ExchangeRate{
+		FromCurrency:  fromCurrency,
+		ToCurrency:    toCurrency,
+		Rate:          scaledRate,
+		EffectiveFrom: effectiveTime.UTC().Format(rateTimeLayout),
+		SetBy:         setBy,
+	}
+	exchangeRateJSON, err := json.Marshal(exchangeRate)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	exchangeRateIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		exchangerateIndexName, []string{fromCurrency, toCurrency, exchangeRate.EffectiveFrom})
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = ctx.GetStub().PutState(exchangeRateIndexKey, exchangeRateJSON)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QueryExchangeRate returns the rate from a currency to another effective at the time at (RFC3339),
+//the rate effective at the transaction time is returned if at is empty
+func (s *SmartContract) QueryExchangeRate(ctx contractapi.TransactionContextInterface, fromCurrency, toCurrency, at string) ExchangeRateQueryResult {
+	var atTime time.Time
+	var err error
+	if at == "" {
+		atTime, err = getTxTime(ctx)
+	} else {
+		atTime, err = time.Parse(time.RFC3339, at)
+	}
+	if err != nil {
+		return ExchangeRateQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ExchangeRate{},
+		}
+	}
+
+	rate, err := getExchangeRate(ctx, fromCurrency, toCurrency, atTime)
+	if err != nil {
+		return ExchangeRateQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ExchangeRate{},
+		}
+	}
+	if rate == nil {
+		return ExchangeRateQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("no exchange rate from %s to %s is effective at %s", fromCurrency, toCurrency, atTime.Format(time.RFC3339)),
+			Data: ExchangeRate{},
+		}
+	}
+	return ExchangeRateQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *rate,
+	}
+}
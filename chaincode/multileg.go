@@ -0,0 +1,174 @@
+//@author: hdsfade
+//@date: 2021-02-24-16:20
+package chaincode
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"strings"
+)
+
+//orderLegs returns all legs of the multi-leg order the order belongs to in order, only the order if it has no other legs
+func orderLegs(ctx contractapi.TransactionContextInterface, order *Order) ([]*Order, error) {
+	first := order
+	for first.PreviousLegOrderId != 0 {
+		previous, err := getOrder(ctx, first.PreviousLegOrderId)
+		if err != nil {
+			return nil, err
+		}
+		first = previous
+	}
+
+	legs := []*Order{first}
+	for leg := first; leg.NextLegOrderId != 0; {
+		next, err := getOrder(ctx, leg.NextLegOrderId)
+		if err != nil {
+			return nil, err
+		}
+		legs = append(legs, next)
+		leg = next
+	}
+	return legs, nil
+}
+
+//CreateMultiLegOrder issues an order transferred between trains, the i-th leg runs on trainNumbers[i]
+//from stations[i] to stations[i+1] and hands the goods over to the next leg there.
+//Every leg is an order of its own with carriages of its train, all legs are created in one transaction
+//so that the order fails as a whole if any train lacks capacity.
+//Customer and goods value are passed in the transient field orderDetails and dangerous goods declared as for CreateOrder,
+//and the ids of the legs are returned in msg.
+func (s *SmartContract) CreateMultiLegOrder(ctx contractapi.TransactionContextInterface, trainNumbers, stations []string,
+	carriageNumber int, carriageType string, totalTypeNum int, cargoType []string, goodsNumber []int, goodsName []string,
+	unNumber []string, hazardClass []string) (Result, error) {
+	if len(trainNumbers) < 2 {
+		return failure(fmt.Errorf("a multi-leg order has %d legs, less than 2", len(trainNumbers)))
+	}
+	if len(stations) != len(trainNumbers)+1 {
+		return failure(fmt.Errorf("a multi-leg order of %d legs must have %d stations but has %d",
+			len(trainNumbers), len(trainNumbers)+1, len(stations)))
+	}
+	//carriages booked for a leg aren't seen by a later leg of the same transaction, so every leg must be on another train
+	for i := 1; i < len(trainNumbers); i++ {
+		for j := 0; j < i; j++ {
+			if trainNumbers[j] == trainNumbers[i] {
+				return failure(fmt.Errorf("the legs %d and %d are both on the train %s", j, i, trainNumbers[i]))
+			}
+		}
+		previousDeparture, err := trainDepartureDate(trainNumbers[i-1])
+		if err != nil {
+			return failure(err)
+		}
+		departure, err := trainDepartureDate(trainNumbers[i])
+		if err != nil {
+			return failure(err)
+		}
+		if departure.Before(previousDeparture) {
+			return failure(fmt.Errorf("the train %s departs before the train %s of the previous leg", trainNumbers[i], trainNumbers[i-1]))
+		}
+	}
+
+	//every leg is checked against the credit limit alone, because the transaction doesn't read its own writes,
+	//so the price of all legs is checked first
+	input, err := readOrderPrivateDetailsInput(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	customer, err := getCustomer(ctx, input.CustomerId)
+	if err != nil {
+		return failure(err)
+	}
+	var total int64
+	for i, trainNumber := range trainNumbers {
+		quote, err := quoteOrder(ctx, trainNumber, stations[i], stations[i+1], carriageNumber, cargoType, customer.BillingCurrency)
+		if err != nil {
+			return failure(err)
+		}
+		total += quote.Price.Amount
+	}
+	err = checkCustomerOrder(ctx, customer, total)
+	if err != nil {
+		return failure(err)
+	}
+
+	legs := []*Order{}
+	for i, trainNumber := range trainNumbers {
+		leg, err := s.createOrder(ctx, trainNumber, stations[i], stations[i+1], carriageNumber, carriageType, totalTypeNum,
+			cargoType, goodsNumber, goodsName, unNumber, hazardClass, nil)
+		if err != nil {
+			return failure(fmt.Errorf("leg %d: %v", i, err))
+		}
+		legs = append(legs, leg)
+	}
+
+	legIds := []string{}
+	for i, leg := range legs {
+		if i > 0 {
+			leg.PreviousLegOrderId = legs[i-1].OrderId
+		}
+		if i+1 < len(legs) {
+			leg.NextLegOrderId = legs[i+1].OrderId
+		}
+		err = putOrder(ctx, leg)
+		if err != nil {
+			return failure(err)
+		}
+		legIds = append(legIds, strconv.Itoa(leg.OrderId))
+	}
+	return Result{
+		Code: 200,
+		Msg:  strings.Join(legIds, ","),
+	}, nil
+}
+
+//DeleteMultiLegOrder deletes all legs of the multi-leg order the order orderId belongs to
+func (s *SmartContract) DeleteMultiLegOrder(ctx contractapi.TransactionContextInterface, orderId int) (Result, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	legs, err := orderLegs(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
+	for _, leg := range legs {
+		err = deleteOrder(ctx, leg)
+		if err != nil {
+			return failure(err)
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//QueryOrderLegs returns all legs of the multi-leg order the order orderId belongs to in order
+func (s *SmartContract) QueryOrderLegs(ctx contractapi.TransactionContextInterface, orderId int) OrderQueryResults {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return OrderQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Orders{OrdersData: []Order{}},
+		}
+	}
+	legs, err := orderLegs(ctx, order)
+	if err != nil {
+		return OrderQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Orders{OrdersData: []Order{}},
+		}
+	}
+
+	orders := []Order{}
+	for _, leg := range legs {
+		orders = append(orders, *leg)
+	}
+	return OrderQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Orders{OrdersData: orders},
+	}
+}
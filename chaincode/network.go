@@ -0,0 +1,247 @@
+//@author: hdsfade
+//@date: 2021-02-24-09:50
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+)
+
+//NetworkEdge describes two consecutive stations of a line, trains of the line run from FromStation to ToStation
+type NetworkEdge struct {
+	LineNumber  int    `json:"lineNumber"`
+	FromStation string `json:"fromStation"`
+	ToStation   string `json:"toStation"`
+	Distance    int    `json:"distance"` //km, 0 if unknown
+}
+
+//Network describes the rail network built from all lines in use, stations are nodes and consecutive way stations edges
+type Network struct { //路网
+	Stations []string      `json:"stations"`
+	Edges    []NetworkEdge `json:"edges"`
+}
+
+//RouteLeg describes a part of a route on one line
+type RouteLeg struct {
+	LineNumber  int    `json:"lineNumber"`
+	FromStation string `json:"fromStation"`
+	ToStation   string `json:"toStation"`
+	Segments    int    `json:"segments"` //number of edges passed
+	Distance    int    `json:"distance"`
+}
+
+//Route describes an itinerary between two stations, direct or with transfers between its legs
+type Route struct { //路线
+	Legs      []RouteLeg `json:"legs"`
+	Transfers int        `json:"transfers"`
+	Distance  int        `json:"distance"`
+}
+
+type Routes struct {
+	RoutesData []Route `json:"routes"`
+}
+
+//NetworkQueryResult structure used for handing result of query network
+type NetworkQueryResult struct {
+	Code int     `json:"code"`
+	Msg  string  `json:"msg"`
+	Data Network `json:"data"`
+}
+
+//RouteQueryResults structure used for handing result of find routes
+type RouteQueryResults struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Routes `json:"data"`
+}
+
+//usingLines returns all lines in use
+func usingLines(ctx contractapi.TransactionContextInterface) ([]Line, error) {
+	lineResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(lineIndexName, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer lineResultsIterator.Close()
+
+	lines := []Line{}
+	for lineResultsIterator.HasNext() {
+		lineQueryResponse, err := lineResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var line Line
+		err = json.Unmarshal(lineQueryResponse.Value, &line)
+		if err != nil {
+			return nil, err
+		}
+		if line.Using {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+//segmentDistance returns the distance from the location-th station of the line to the next one,
+//0 if the line was created without segments
+func (line *Line) segmentDistance(location int) int {
+	if location >= len(line.Segments) {
+		return 0
+	}
+	return line.Segments[location].Distance
+}
+
+//leg returns the leg of the line from the from-th station to the to-th station
+func (line *Line) leg(from, to int) RouteLeg {
+	leg := RouteLeg{
+		LineNumber:  line.LineNumber,
+		FromStation: line.WayStation[from],
+		ToStation:   line.WayStation[to],
+		Segments:    to - from,
+	}
+	for location := from; location < to; location++ {
+		leg.Distance += line.segmentDistance(location)
+	}
+	return leg
+}
+
+//findRoutes returns the direct routes and the routes with one transfer from startingStation to destinationStation,
+//ordered by transfers, distance and segments
+func findRoutes(lines []Line, startingStation, destinationStation string) []Route {
+	routes := []Route{}
+	for i := range lines {
+		first := &lines[i]
+		from := first.stationIndex(startingStation)
+		if from < 0 {
+			continue
+		}
+		to := first.stationIndex(destinationStation)
+		if to > from {
+			leg := first.leg(from, to)
+			routes = append(routes, Route{
+				Legs:      []RouteLeg{leg},
+				Transfers: 0,
+				Distance:  leg.Distance,
+			})
+		}
+
+		//transfer at every later station of the first line which isn't the destination
+		for transfer := from + 1; transfer < len(first.WayStation); transfer++ {
+			if transfer == to {
+				break
+			}
+			for j := range lines {
+				second := &lines[j]
+				if j == i {
+					continue
+				}
+				secondFrom, secondTo := second.stationIndex(first.WayStation[transfer]), second.stationIndex(destinationStation)
+				if secondFrom < 0 || secondTo <= secondFrom {
+					continue
+				}
+				//a route passing the starting station again isn't a sensible connection
+				if start := second.stationIndex(startingStation); start > secondFrom && start < secondTo {
+					continue
+				}
+				firstLeg, secondLeg := first.leg(from, transfer), second.leg(secondFrom, secondTo)
+				routes = append(routes, Route{
+					Legs:      []RouteLeg{firstLeg, secondLeg},
+					Transfers: 1,
+					Distance:  firstLeg.Distance + secondLeg.Distance,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Transfers != routes[j].Transfers {
+			return routes[i].Transfers < routes[j].Transfers
+		}
+		if routes[i].Distance != routes[j].Distance {
+			return routes[i].Distance < routes[j].Distance
+		}
+		return routeSegments(&routes[i]) < routeSegments(&routes[j])
+	})
+	return routes
+}
+
+//routeSegments returns the number of edges the route passes
+func routeSegments(route *Route) int {
+	segments := 0
+	for _, leg := range route.Legs {
+		segments += leg.Segments
+	}
+	return segments
+}
+
+//QueryNetwork returns the rail network built from all lines in use
+func (s *SmartContract) QueryNetwork(ctx contractapi.TransactionContextInterface) NetworkQueryResult {
+	lines, err := usingLines(ctx)
+	if err != nil {
+		return NetworkQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Network{Stations: []string{}, Edges: []NetworkEdge{}},
+		}
+	}
+
+	network := Network{Stations: []string{}, Edges: []NetworkEdge{}}
+	stations := make(map[string]bool)
+	for i := range lines {
+		line := &lines[i]
+		for location, stationName := range line.WayStation {
+			if !stations[stationName] {
+				stations[stationName] = true
+				network.Stations = append(network.Stations, stationName)
+			}
+			if location+1 < len(line.WayStation) {
+				network.Edges = append(network.Edges, NetworkEdge{
+					LineNumber:  line.LineNumber,
+					FromStation: stationName,
+					ToStation:   line.WayStation[location+1],
+					Distance:    line.segmentDistance(location),
+				})
+			}
+		}
+	}
+	sort.Strings(network.Stations)
+	return NetworkQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: network,
+	}
+}
+
+//FindRoutes returns the direct and one-transfer routes from startingStation to destinationStation on lines in use
+func (s *SmartContract) FindRoutes(ctx contractapi.TransactionContextInterface, startingStation, destinationStation string) RouteQueryResults {
+	if startingStation == destinationStation {
+		return RouteQueryResults{
+			Code: 402,
+			Msg:  fmt.Sprintf("the starting station and the destination station are both %s", startingStation),
+			Data: Routes{RoutesData: []Route{}},
+		}
+	}
+	lines, err := usingLines(ctx)
+	if err != nil {
+		return RouteQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Routes{RoutesData: []Route{}},
+		}
+	}
+
+	routes := findRoutes(lines, startingStation, destinationStation)
+	if len(routes) == 0 {
+		return RouteQueryResults{
+			Code: 402,
+			Msg:  fmt.Sprintf("no route from %s to %s", startingStation, destinationStation),
+			Data: Routes{RoutesData: []Route{}},
+		}
+	}
+	return RouteQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Routes{RoutesData: routes},
+	}
+}
@@ -3,6 +3,8 @@
 package chaincode
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
@@ -11,26 +13,82 @@ import (
 )
 
 var orderIndexName = "order"
+var orderPrivateDetailsTransientKey = "orderDetails"
 var trainorderIndexName = "train~order"
 
 var orderId = 0
 
+//order states
+const (
+	OrderStateBooked    = "booked"    //已订舱
+	OrderStateOnHold    = "onhold"    //扣留
+	OrderStateLoaded    = "loaded"    //已装车
+	OrderStateOffloaded = "offloaded" //已卸车
+	OrderStateDelivered = "delivered" //已交付
+	OrderStateClosed    = "closed"    //已结案
+)
+
+//order payment states
+const (
+	PaymentStateUnpaid   = "unpaid"   //未付款
+	PaymentStateInvoiced = "invoiced" //已开票
+	PaymentStatePaid     = "paid"     //已付款
+)
+
 //Order describes details of a order
 type Order struct { //订单
 	OrderId            int      `json:"orderId"`
 	GenerateTime       string   `json:"generateTime"`
-	CustomerId         int      `json:"customerId"`
 	TrainNumber        string   `json:"trainNumber"`
 	StartingStation    string   `json:"startingStation"`
 	DestinationStation string   `json:"destinationStation"`
 	CarriageNumber     int      `json:"carriageNumber"`
-	Price              int      `json:"price"`        //订单金额
-	TotalTypeNum       int      `json:"totalTypeNum"` //订单中也要货物信息
+	Carriages          []int    `json:"carriages,omitempty" metadata:",optional"`  //positions of the carriages taken in the train
+	Containers         []string `json:"containers,omitempty" metadata:",optional"` //numbers of the containers loaded for the order
+	TotalTypeNum       int      `json:"totalTypeNum"`                              //订单中也要货物信息
 	CargoType          []string `json:"cargoType"`
 	GoodsNum           []int    `json:"goodsNum"`
 	GoodsName          []string `json:"goodsName"`
+	UNNumber           []string `json:"unNumber,omitempty" metadata:",optional"`    //UN numbers of dangerous goods in the order of GoodsName
+	HazardClass        []string `json:"hazardClass,omitempty" metadata:",optional"` //ADR/RID classes, empty for goods which are not dangerous
 	CheckResult        bool     `json:"checkResult"`
 	CheckDescription   string   `json:"checkDescription"`
+	State              string   `json:"state"`
+	OffloadStation     string   `json:"offloadStation,omitempty" metadata:",optional"`
+	//consignment details required by CIM/SMGS consignment notes
+	Consignor           Party                `json:"consignor"`
+	Consignee           Party                `json:"consignee"`
+	BorderPoints        []string             `json:"borderPoints,omitempty" metadata:",optional"`
+	CustomsDeclarations []CustomsDeclaration `json:"customsDeclarations,omitempty" metadata:",optional"`
+	//customer and price are kept in the private data collection shared by the customer's organization and the operator
+	PrivateCollection  string `json:"privateCollection"`
+	PrivateDetailsHash string `json:"privateDetailsHash"` //hex encoded sha256 of the private details
+	PrepaymentRequired bool   `json:"prepaymentRequired"` //the order couldn't be loaded until it is paid
+	PaymentState       string `json:"paymentState"`
+	InvoiceId          string `json:"invoiceId"`
+	StateBeforeHold    string `json:"stateBeforeHold,omitempty" metadata:",optional"`
+	//a leg of a multi-leg order takes over the goods from the previous leg at its starting station
+	//and hands them over to the next leg at its destination station
+	PreviousLegOrderId int `json:"previousLegOrderId,omitempty" metadata:",optional"`
+	NextLegOrderId     int `json:"nextLegOrderId,omitempty" metadata:",optional"`
+	//ranges sensor readings of the goods must stay in, e.g. temperature of refrigerated goods
+	Thresholds []SensorThreshold `json:"thresholds,omitempty" metadata:",optional"`
+}
+
+//OrderPrivateDetails describes commercially sensitive details of a order
+type OrderPrivateDetails struct {
+	OrderId    int    `json:"orderId"`
+	CustomerId int    `json:"customerId"`
+	Price      Money  `json:"price"`      //订单金额, in the customer's billing currency
+	GoodsValue Money  `json:"goodsValue"` //货值
+	Salt       string `json:"salt"`       //random salt keeps the public hash from being guessed
+}
+
+//OrderPrivateDetailsQueryResult structure used for handing result of query private details
+type OrderPrivateDetailsQueryResult struct {
+	Code int                 `json:"code"`
+	Msg  string              `json:"msg"`
+	Data OrderPrivateDetails `json:"data"`
 }
 
 type Orders struct {
@@ -65,184 +123,455 @@ func (s *SmartContract) OrderExists(ctx contractapi.TransactionContextInterface,
 	return orderJSON != nil, nil
 }
 
+//orderOffTrain judges if the goods of the order have left its train, i.e. it is offloaded, delivered or closed
+func orderOffTrain(order *Order) bool {
+	return order.State == OrderStateOffloaded || order.State == OrderStateDelivered || order.State == OrderStateClosed
+}
+
+//getOrder reads the order with given orderId from the world state
+func getOrder(ctx contractapi.TransactionContextInterface, orderId int) (*Order, error) {
+	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(orderId)})
+	if err != nil {
+		return nil, err
+	}
+	orderJSON, err := ctx.GetStub().GetState(orderIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if orderJSON == nil {
+		return nil, fmt.Errorf("the order %d does not exist", orderId)
+	}
+
+	var order Order
+	err = json.Unmarshal(orderJSON, &order)
+	if err != nil {
+		return nil, err
+	}
+	if order.State == "" {
+		order.State = OrderStateBooked
+	}
+	if order.PaymentState == "" {
+		order.PaymentState = PaymentStateUnpaid
+	}
+	return &order, nil
+}
+
+//putOrder writes the order to the world state
+func putOrder(ctx contractapi.TransactionContextInterface, order *Order) error {
+	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(order.OrderId)})
+	if err != nil {
+		return err
+	}
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(orderIndexKey, orderJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//orderPrivateDetailsInput describes the private details of a new order passed in the transient map
+type orderPrivateDetailsInput struct {
+	CustomerId int    `json:"customerId"`
+	GoodsValue Money  `json:"goodsValue"`
+	Salt       string `json:"salt"`
+}
+
+//orderCollectionName returns the private data collection shared by the organization mspId and the operator
+func orderCollectionName(mspId string) string {
+	return "orderCollection" + mspId
+}
+
+//readOrderPrivateDetailsInput reads the private details of a new order from the transient map
+func readOrderPrivateDetailsInput(ctx contractapi.TransactionContextInterface) (*orderPrivateDetailsInput, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transient: %v", err)
+	}
+	inputJSON, ok := transientMap[orderPrivateDetailsTransientKey]
+	if !ok {
+		return nil, fmt.Errorf("the order's private details must be passed in the transient field %s", orderPrivateDetailsTransientKey)
+	}
+	var input orderPrivateDetailsInput
+	err = json.Unmarshal(inputJSON, &input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the order's private details: %v", err)
+	}
+	if input.GoodsValue.Amount < 0 {
+		return nil, fmt.Errorf("the order's goods value %d must not be negative", input.GoodsValue.Amount)
+	}
+	err = checkCurrency(input.GoodsValue.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if input.Salt == "" {
+		return nil, fmt.Errorf("the order's private details must have a salt")
+	}
+	return &input, nil
+}
+
+//putOrderPrivateDetails writes the private details to the collection and returns their hash
+func putOrderPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, details *OrderPrivateDetails) (string, error) {
+	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(details.OrderId)})
+	if err != nil {
+		return "", err
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, orderIndexKey, detailsJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to put to private data collection %s. %v", collection, err)
+	}
+	hash := sha256.Sum256(detailsJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+//getOrderPrivateDetails reads the private details of the order from its collection
+func getOrderPrivateDetails(ctx contractapi.TransactionContextInterface, order *Order) (*OrderPrivateDetails, error) {
+	if order.PrivateCollection == "" {
+		return nil, fmt.Errorf("the order %d has no private details", order.OrderId)
+	}
+	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(order.OrderId)})
+	if err != nil {
+		return nil, err
+	}
+	detailsJSON, err := ctx.GetStub().GetPrivateData(order.PrivateCollection, orderIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s %v", order.PrivateCollection, err)
+	}
+	if detailsJSON == nil {
+		return nil, fmt.Errorf("the order %d's private details are not in the collection %s", order.OrderId, order.PrivateCollection)
+	}
+
+	var details OrderPrivateDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+//loadOrder moves a booked order to loaded, an order requiring prepayment couldn't be loaded until it is paid
+func loadOrder(order *Order) error {
+	if order.State != OrderStateBooked {
+		return fmt.Errorf("the order %d is %s, only a booked order could be loaded", order.OrderId, order.State)
+	}
+	if order.PrepaymentRequired && order.PaymentState != PaymentStatePaid {
+		return fmt.Errorf("the order %d requires prepayment but is %s", order.OrderId, order.PaymentState)
+	}
+	order.State = OrderStateLoaded
+	return nil
+}
+
+//trainOrderIds returns ids of all orders booked on the train trainNumber
+func trainOrderIds(ctx contractapi.TransactionContextInterface, trainNumber string) ([]int, error) {
+	orderResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(trainorderIndexName, []string{trainNumber})
+	if err != nil {
+		return nil, err
+	}
+	defer orderResultsIterator.Close()
+
+	orderIds := []int{}
+	for orderResultsIterator.HasNext() {
+		orderQueryResponse, err := orderResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		orderId, err := strconv.Atoi(string(orderQueryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		orderIds = append(orderIds, orderId)
+	}
+	return orderIds, nil
+}
+
 //CreateOrder issues a new order to the world state with given details.
-func (s *SmartContract) CreateOrder(ctx contractapi.TransactionContextInterface, customerId int, trainNumber string,
-	startingStation, destinationStation string, carriageNumber, price, totalTypeNum int, cargoType []string, goodsNumber []int,
-	goodsName []string) Result {
+//The order is checked before anything is written, and a failure is returned as an error
+//so that Fabric discards all writes of the transaction.
+//Customer and goods value are passed in the transient field orderDetails, they are kept in a private data collection
+//together with the price quoted from the tariff in the customer's billing currency.
+//Free carriages of carriageType (any type if empty) are allocated to the order.
+//unNumber and hazardClass give the UN number and ADR/RID class of every dangerous goods in the order of goodsName,
+//the order is rejected if its dangerous goods violate the segregation table with goods on the train.
+func (s *SmartContract) CreateOrder(ctx contractapi.TransactionContextInterface, trainNumber string,
+	startingStation, destinationStation string, carriageNumber int, carriageType string, totalTypeNum int, cargoType []string,
+	goodsNumber []int, goodsName []string, unNumber []string, hazardClass []string) (Result, error) {
+	_, err := s.createOrder(ctx, trainNumber, startingStation, destinationStation, carriageNumber, carriageType, totalTypeNum,
+		cargoType, goodsNumber, goodsName, unNumber, hazardClass, nil)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//createOrder issues a new order with the private details passed in the transient field orderDetails and returns it.
+//The order of a reservation takes its price and carriages, otherwise the order is quoted and reserves carriages of the train.
+func (s *SmartContract) createOrder(ctx contractapi.TransactionContextInterface, trainNumber string,
+	startingStation, destinationStation string, carriageNumber int, carriageType string, totalTypeNum int, cargoType []string,
+	goodsNumber []int, goodsName []string, unNumber []string, hazardClass []string, reservation *Reservation) (*Order, error) {
 	orderId++
 	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(orderId)})
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
+	}
+	trainorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		trainorderIndexName, []string{trainNumber, strconv.Itoa(orderId)})
+	if err != nil {
+		orderId--
+		return nil, err
 	}
 
 	exists, err := s.OrderExists(ctx, orderId)
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
 	if exists {
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the order %d already exists", orderId),
-		}
+		return nil, fmt.Errorf("the order %d already exists", orderId)
 	}
 
 	//if the train trainNumber does not exist, the order couldn't be created
 	exists, err = s.TrainExists(ctx, trainNumber)
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
 	if exists == false {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the train %s does not exist", trainNumber),
-		}
+		return nil, fmt.Errorf("the train %s does not exist", trainNumber)
 	}
 
-	updateTrainResult := s.UpdateTrain(ctx, trainNumber, carriageNumber)
-	if updateTrainResult.Code != 200 {
+	unNumber, hazardClass, err = checkDangerousGoods(goodsName, unNumber, hazardClass)
+	if err != nil {
 		orderId--
-		return updateTrainResult
+		return nil, err
 	}
 
+	input, err := readOrderPrivateDetailsInput(ctx)
+	if err != nil {
+		orderId--
+		return nil, err
+	}
+
+	//the customer must be active and have enough credit, the details are shared by the customer's organization and the operator
+	customer, err := getCustomer(ctx, input.CustomerId)
+	if err != nil {
+		orderId--
+		return nil, err
+	}
+	var price Money
+	if reservation == nil {
+		quote, err := quoteOrder(ctx, trainNumber, startingStation, destinationStation, carriageNumber, cargoType, customer.BillingCurrency)
+		if err != nil {
+			orderId--
+			return nil, err
+		}
+		price = quote.Price
+	} else {
+		details, err := getReservationPrivateDetails(ctx, reservation)
+		if err != nil {
+			orderId--
+			return nil, err
+		}
+		if details.CustomerId != input.CustomerId {
+			orderId--
+			return nil, fmt.Errorf("the reservation %s doesn't belong to customer %d", reservation.ReservationId, input.CustomerId)
+		}
+		price = details.Price
+	}
+	//the price of an active reservation is already counted by the customer's outstanding amount
+	creditPrice := price.Amount
+	if reservation != nil {
+		creditPrice = 0
+	}
+	err = checkCustomerOrder(ctx, customer, creditPrice)
+	if err != nil {
+		orderId--
+		return nil, err
+	}
+	collection := orderCollectionName(customer.MSPID)
+
 	order := Order{
 		OrderId:            orderId,
 		GenerateTime:       time.Now().Format("2006-01-02"),
-		CustomerId:         customerId,
 		TrainNumber:        trainNumber,
 		StartingStation:    startingStation,
 		DestinationStation: destinationStation,
 		CarriageNumber:     carriageNumber,
-		Price:              price,
 		TotalTypeNum:       totalTypeNum,
 		CargoType:          cargoType,
 		GoodsNum:           goodsNumber,
 		GoodsName:          goodsName,
+		UNNumber:           unNumber,
+		HazardClass:        hazardClass,
 		CheckResult:        false,
 		CheckDescription:   " ",
+		State:              OrderStateBooked,
+		PrivateCollection:  collection,
+		PrepaymentRequired: customer.PaymentTerms == PaymentTermsPrepayment,
+		PaymentState:       PaymentStateUnpaid,
+		InvoiceId:          "",
+	}
+	order.PrivateDetailsHash, err = putOrderPrivateDetails(ctx, collection, &OrderPrivateDetails{
+		OrderId:    orderId,
+		CustomerId: input.CustomerId,
+		Price:      price,
+		GoodsValue: input.GoodsValue,
+		Salt:       input.Salt,
+	})
+	if err != nil {
+		orderId--
+		return nil, err
 	}
-	orderJSON, err := json.Marshal(order)
+	err = putCustomerOrder(ctx, collection, input.CustomerId, orderId)
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
 
-	err = ctx.GetStub().PutState(orderIndexKey, orderJSON)
+	//carriages of a reservation are booked for the order, otherwise free carriages of the train are allocated
+	if reservation == nil {
+		order.Carriages, err = allocateCarriages(ctx, trainNumber, carriageNumber, carriageType, orderId, "")
+	} else {
+		order.Carriages = reservation.Carriages
+		err = bookReservedCarriages(ctx, trainNumber, reservation.Carriages, reservation.ReservationId, orderId)
+	}
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
+	}
+	err = checkTrainSegregation(ctx, &order)
+	if err != nil {
+		orderId--
+		return nil, err
+	}
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		orderId--
+		return nil, err
 	}
 
-	//create compositekey train~order
-	trainorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
-		trainorderIndexName, []string{trainNumber, strconv.Itoa(orderId)})
+	err = ctx.GetStub().PutState(orderIndexKey, orderJSON)
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
+
+	//create compositekey train~order
 	err = ctx.GetStub().PutState(trainorderIndexKey, []byte(strconv.Itoa(orderId)))
 	if err != nil {
 		orderId--
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
 
+	return &order, nil
+}
+
+//DeleteOrder deletes a booked order by orderId from the world state,
+//a leg of a multi-leg order could only be deleted together with the other legs by DeleteMultiLegOrder
+func (s *SmartContract) DeleteOrder(ctx contractapi.TransactionContextInterface, orderId int) (Result, error) {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return failure(err)
+	}
+	if order.PreviousLegOrderId != 0 || order.NextLegOrderId != 0 {
+		return failure(fmt.Errorf("the order %d is a leg of a multi-leg order, delete it by DeleteMultiLegOrder", orderId))
+	}
+	err = deleteOrder(ctx, order)
+	if err != nil {
+		return failure(err)
+	}
 	return Result{
 		Code: 200,
 		Msg:  "success",
-	}
+	}, nil
 }
 
-//DeleteOrder deletes a order by orderId from the world state
-func (s *SmartContract) DeleteOrder(ctx contractapi.TransactionContextInterface, orderId int) Result {
-	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(orderId)})
-	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+//deleteOrder deletes the order, its carriages and private details from the world state.
+//Everything is read and checked before anything is written, the caller must return a failure as an error.
+func deleteOrder(ctx contractapi.TransactionContextInterface, order *Order) error {
+	//a loaded order is listed by the sealed cargo of its train, a booked one at most by a draft cargo
+	if order.State != OrderStateBooked {
+		return fmt.Errorf("the order %d is %s, only a booked order could be deleted", order.OrderId, order.State)
+	}
+	//a container loaded for the order must be unloaded first, or it couldn't be unloaded at all
+	for _, containerNumber := range order.Containers {
+		container, err := getContainer(ctx, containerNumber)
+		if err != nil {
+			return err
+		}
+		if container.TrainNumber != "" && container.OrderId == order.OrderId {
+			return fmt.Errorf("the container %s is loaded for the order %d on the train %s", containerNumber, order.OrderId, container.TrainNumber)
 		}
 	}
-	orderJSON, err := ctx.GetStub().GetState(orderIndexKey)
+	//a seal of the order's goods is checked by every cargo check of the train
+	seals, err := trainSeals(ctx, order.TrainNumber)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return err
 	}
-	if orderJSON == nil {
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the order %d does not exist", orderId),
+	for _, seal := range seals {
+		if seal.OrderId == order.OrderId && seal.State == SealStateIntact {
+			return fmt.Errorf("the seal %s of the order %d is on the %s of the train %s", seal.SealNumber, order.OrderId, seal.sealUnit(), order.TrainNumber)
 		}
 	}
-
-	var order Order
-	err = json.Unmarshal(orderJSON, &order)
+	orderIndexKey, err := ctx.GetStub().CreateCompositeKey(orderIndexName, []string{strconv.Itoa(order.OrderId)})
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+		return err
+	}
+	trainorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		trainorderIndexName, []string{order.TrainNumber, strconv.Itoa(order.OrderId)})
+	if err != nil {
+		return err
+	}
+	//the private details are only readable on peers of the collection
+	var details *OrderPrivateDetails
+	if order.PrivateCollection != "" {
+		details, err = getOrderPrivateDetails(ctx, order)
+		if err != nil {
+			return err
 		}
 	}
 
-	//recover train's carriageNumber
-	updateTrainResult := s.UpdateTrain(ctx, order.TrainNumber, -order.CarriageNumber)
-	if updateTrainResult.Code != 200 {
-		return updateTrainResult
+	//a draft cargo listing the order is revised without it
+	err = removeCargoOrder(ctx, order)
+	if err != nil {
+		return err
 	}
-
-	//delete train~order
-	trainorderIndexKey, err := ctx.GetStub().CreateCompositeKey(
-		trainorderIndexName, []string{order.TrainNumber, strconv.Itoa(orderId)})
+	//recover train's carriages
+	err = releaseCarriages(ctx, order.TrainNumber, order.Carriages, order.OrderId, "")
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return err
 	}
+	//delete train~order
 	err = ctx.GetStub().DelState(trainorderIndexKey)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+		return fmt.Errorf("failed to delete from world state. %v", err)
+	}
+	if details != nil {
+		err = delCustomerOrder(ctx, order.PrivateCollection, details.CustomerId, order.OrderId)
+		if err != nil {
+			return err
+		}
+		err = ctx.GetStub().DelPrivateData(order.PrivateCollection, orderIndexKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete from private data collection %s. %v", order.PrivateCollection, err)
 		}
 	}
-
 	err = ctx.GetStub().DelState(orderIndexKey)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
-	}
-
-	return Result{
-		Code: 200,
-		Msg:  "success",
+		return fmt.Errorf("failed to delete from world state. %v", err)
 	}
+	return nil
 }
 
 //UpdateOrder updates an existing order in the world state with provided parameters
@@ -314,12 +643,10 @@ func (s *SmartContract) QueryOrderByorderid(ctx contractapi.TransactionContextIn
 			Data: Order{
 				OrderId:            0,
 				GenerateTime:       "",
-				CustomerId:         0,
 				TrainNumber:        "0",
 				StartingStation:    "",
 				DestinationStation: "",
 				CarriageNumber:     0,
-				Price:              0,
 				TotalTypeNum:       0,
 				CargoType:          []string{},
 				GoodsNum:           []int{},
@@ -338,12 +665,10 @@ func (s *SmartContract) QueryOrderByorderid(ctx contractapi.TransactionContextIn
 			Data: Order{
 				OrderId:            0,
 				GenerateTime:       "",
-				CustomerId:         0,
 				TrainNumber:        "0",
 				StartingStation:    "",
 				DestinationStation: "",
 				CarriageNumber:     0,
-				Price:              0,
 				TotalTypeNum:       0,
 				CargoType:          []string{},
 				GoodsNum:           []int{},
@@ -360,12 +685,10 @@ func (s *SmartContract) QueryOrderByorderid(ctx contractapi.TransactionContextIn
 			Data: Order{
 				OrderId:            0,
 				GenerateTime:       "",
-				CustomerId:         0,
 				TrainNumber:        "0",
 				StartingStation:    "",
 				DestinationStation: "",
 				CarriageNumber:     0,
-				Price:              0,
 				TotalTypeNum:       0,
 				CargoType:          []string{},
 				GoodsNum:           []int{},
@@ -385,12 +708,10 @@ func (s *SmartContract) QueryOrderByorderid(ctx contractapi.TransactionContextIn
 			Data: Order{
 				OrderId:            0,
 				GenerateTime:       "",
-				CustomerId:         0,
 				TrainNumber:        "0",
 				StartingStation:    "",
 				DestinationStation: "",
 				CarriageNumber:     0,
-				Price:              0,
 				TotalTypeNum:       0,
 				CargoType:          []string{},
 				GoodsNum:           []int{},
@@ -408,18 +729,42 @@ func (s *SmartContract) QueryOrderByorderid(ctx contractapi.TransactionContextIn
 	}
 }
 
+//QueryOrderPrivateDetails returns the private details of the order with given orderId,
+//only members of the order's private data collection could read them
+func (s *SmartContract) QueryOrderPrivateDetails(ctx contractapi.TransactionContextInterface, orderId int) OrderPrivateDetailsQueryResult {
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return OrderPrivateDetailsQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: OrderPrivateDetails{},
+		}
+	}
+	details, err := getOrderPrivateDetails(ctx, order)
+	if err != nil {
+		return OrderPrivateDetailsQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: OrderPrivateDetails{},
+		}
+	}
+	return OrderPrivateDetailsQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *details,
+	}
+}
+
 //QueryAllOrders returns all orders found in world state
 func (s *SmartContract) QueryAllOrders(ctx contractapi.TransactionContextInterface) OrderQueryResults {
 	var emptyorders []Order
 	emptyorders = append(emptyorders, Order{
 		OrderId:            0,
 		GenerateTime:       " ",
-		CustomerId:         0,
 		TrainNumber:        "0",
 		StartingStation:    " ",
 		DestinationStation: " ",
 		CarriageNumber:     0,
-		Price:              0,
 		TotalTypeNum:       0,
 		CargoType:          []string{},
 		GoodsNum:           []int{},
@@ -0,0 +1,474 @@
+//@author: hdsfade
+//@date: 2021-02-20-09:40
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
+)
+
+var reservationIndexName = "reservation"
+var reservationexpiryIndexName = "reservation~expiry"
+var customerreservationIndexName = "customer~reservation"
+var reservationPrivateDetailsTransientKey = "reservationDetails"
+
+//reservationLifetime is how long a reservation holds carriages of a train
+var reservationLifetime = 30 * time.Minute
+
+//reservation states
+const (
+	ReservationStateActive    = "active"    //预留中
+	ReservationStateConfirmed = "confirmed" //已确认
+	ReservationStateExpired   = "expired"   //已过期
+)
+
+//Reservation describes carriages of a train held for a customer at a quoted price until ExpiryTime
+type Reservation struct { //预留
+	ReservationId      string   `json:"reservationId"`
+	TrainNumber        string   `json:"trainNumber"`
+	StartingStation    string   `json:"startingStation"`
+	DestinationStation string   `json:"destinationStation"`
+	CarriageNumber     int      `json:"carriageNumber"`
+	CarriageType       string   `json:"carriageType"` //any type if empty
+	Carriages          []int    `json:"carriages"`
+	CargoType          []string `json:"cargoType"`
+	Holder             string   `json:"holder"` //identity of the client who reserved
+	PrivateCollection  string   `json:"privateCollection"`
+	CreateTime         string   `json:"createTime"`
+	ExpiryTime         string   `json:"expiryTime"`
+	State              string   `json:"state"`
+	OrderId            int      `json:"orderId"` //order confirmed from the reservation
+}
+
+//ReservationPrivateDetails describes the customer and quoted price of a reservation
+type ReservationPrivateDetails struct {
+	ReservationId string `json:"reservationId"`
+	CustomerId    int    `json:"customerId"`
+	Price         Money  `json:"price"` //in the customer's billing currency
+	QuoteTime     string `json:"quoteTime"`
+}
+
+//reservationPrivateDetailsInput describes the private details of a new reservation passed in the transient map
+type reservationPrivateDetailsInput struct {
+	CustomerId int `json:"customerId"`
+}
+
+//ReservationQueryResult structure used for handing result of query
+type ReservationQueryResult struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data Reservation `json:"data"`
+}
+
+//ReservationPrivateDetailsQueryResult structure used for handing result of query private details
+type ReservationPrivateDetailsQueryResult struct {
+	Code int                       `json:"code"`
+	Msg  string                    `json:"msg"`
+	Data ReservationPrivateDetails `json:"data"`
+}
+
+//getReservation reads the reservation from the world state
+func getReservation(ctx contractapi.TransactionContextInterface, reservationId string) (*Reservation, error) {
+	reservationIndexKey, err := ctx.GetStub().CreateCompositeKey(reservationIndexName, []string{reservationId})
+	if err != nil {
+		return nil, err
+	}
+	reservationJSON, err := ctx.GetStub().GetState(reservationIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if reservationJSON == nil {
+		return nil, fmt.Errorf("the reservation %s does not exist", reservationId)
+	}
+
+	var reservation Reservation
+	err = json.Unmarshal(reservationJSON, &reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+//putReservation writes the reservation to the world state
+func putReservation(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	reservationIndexKey, err := ctx.GetStub().CreateCompositeKey(reservationIndexName, []string{reservation.ReservationId})
+	if err != nil {
+		return err
+	}
+	reservationJSON, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(reservationIndexKey, reservationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//getReservationPrivateDetails reads the private details of the reservation from its collection
+func getReservationPrivateDetails(ctx contractapi.TransactionContextInterface, reservation *Reservation) (*ReservationPrivateDetails, error) {
+	reservationIndexKey, err := ctx.GetStub().CreateCompositeKey(reservationIndexName, []string{reservation.ReservationId})
+	if err != nil {
+		return nil, err
+	}
+	detailsJSON, err := ctx.GetStub().GetPrivateData(reservation.PrivateCollection, reservationIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s %v", reservation.PrivateCollection, err)
+	}
+	if detailsJSON == nil {
+		return nil, fmt.Errorf("the reservation %s's private details are not in the collection %s", reservation.ReservationId, reservation.PrivateCollection)
+	}
+
+	var details ReservationPrivateDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+//putReservationExpiry creates compositekey reservation~expiry of an active reservation,
+//keys are ordered by expiry time so that expired reservations are found without reading the others
+func putReservationExpiry(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	reservationexpiryIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		reservationexpiryIndexName, []string{reservation.ExpiryTime, reservation.ReservationId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutState(reservationexpiryIndexKey, []byte{0x00})
+}
+
+//delReservationExpiry deletes compositekey reservation~expiry of a reservation which is no longer active
+func delReservationExpiry(ctx contractapi.TransactionContextInterface, reservation *Reservation) error {
+	reservationexpiryIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		reservationexpiryIndexName, []string{reservation.ExpiryTime, reservation.ReservationId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelState(reservationexpiryIndexKey)
+}
+
+//putCustomerReservation creates compositekey customer~reservation in the private data collection of the reservation
+func putCustomerReservation(ctx contractapi.TransactionContextInterface, collection string, customerId int, reservationId string) error {
+	customerreservationIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		customerreservationIndexName, []string{strconv.Itoa(customerId), reservationId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().PutPrivateData(collection, customerreservationIndexKey, []byte(reservationId))
+}
+
+//delCustomerReservation deletes compositekey customer~reservation from the private data collection of the reservation
+func delCustomerReservation(ctx contractapi.TransactionContextInterface, collection string, customerId int, reservationId string) error {
+	customerreservationIndexKey, err := ctx.GetStub().CreateCompositeKey(
+		customerreservationIndexName, []string{strconv.Itoa(customerId), reservationId})
+	if err != nil {
+		return err
+	}
+	return ctx.GetStub().DelPrivateData(collection, customerreservationIndexKey)
+}
+
+//customerReservations returns the reservations of the customer that haven't been confirmed
+func customerReservations(ctx contractapi.TransactionContextInterface, customer *Customer) ([]*Reservation, error) {
+	reservationResultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(
+		orderCollectionName(customer.MSPID), customerreservationIndexName, []string{strconv.Itoa(customer.CustomerId)})
+	if err != nil {
+		return nil, err
+	}
+	defer reservationResultsIterator.Close()
+
+	var reservations []*Reservation
+	for reservationResultsIterator.HasNext() {
+		reservationQueryResponse, err := reservationResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		reservation, err := getReservation(ctx, string(reservationQueryResponse.Value))
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	return reservations, nil
+}
+
+//reservationExpired judges the reservation if expired at the time or not
+func reservationExpired(reservation *Reservation, at time.Time) (bool, error) {
+	expiryTime, err := time.Parse(time.RFC3339, reservation.ExpiryTime)
+	if err != nil {
+		return false, err
+	}
+	return !at.Before(expiryTime), nil
+}
+
+//ReserveCapacity holds carriageNumber carriages of carriageType (any type if empty) of the train at a quoted price,
+//the reservation expires after reservationLifetime.
+//The customer is passed in the transient field reservationDetails, e.g. {"customerId":1}.
+//The id of the reservation is the transaction id, and its price counts against the customer's credit limit while it is active.
+func (s *SmartContract) ReserveCapacity(ctx contractapi.TransactionContextInterface, trainNumber, startingStation,
+	destinationStation string, carriageNumber int, carriageType string, cargoType []string) (Result, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return failure(fmt.Errorf("failed to get transient: %v", err))
+	}
+	inputJSON, ok := transientMap[reservationPrivateDetailsTransientKey]
+	if !ok {
+		return failure(fmt.Errorf("the reservation's customer must be passed in the transient field %s", reservationPrivateDetailsTransientKey))
+	}
+	var input reservationPrivateDetailsInput
+	err = json.Unmarshal(inputJSON, &input)
+	if err != nil {
+		return failure(fmt.Errorf("failed to unmarshal the reservation's private details: %v", err))
+	}
+
+	customer, err := getCustomer(ctx, input.CustomerId)
+	if err != nil {
+		return failure(err)
+	}
+	quote, err := quoteOrder(ctx, trainNumber, startingStation, destinationStation, carriageNumber, cargoType, customer.BillingCurrency)
+	if err != nil {
+		return failure(err)
+	}
+	err = checkCustomerOrder(ctx, customer, quote.Price.Amount)
+	if err != nil {
+		return failure(err)
+	}
+	holder, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return failure(fmt.Errorf("failed to get client's identity: %v", err))
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+
+	reservationId := ctx.GetStub().GetTxID()
+	carriages, err := allocateCarriages(ctx, trainNumber, carriageNumber, carriageType, 0, reservationId)
+	if err != nil {
+		return failure(err)
+	}
+
+	reservation := Reservation{
+		ReservationId:      reservationId,
+		TrainNumber:        trainNumber,
+		StartingStation:    startingStation,
+		DestinationStation: destinationStation,
+		CarriageNumber:     carriageNumber,
+		CarriageType:       carriageType,
+		Carriages:          carriages,
+		CargoType:          quote.CargoType,
+		Holder:             holder,
+		PrivateCollection:  orderCollectionName(customer.MSPID),
+		CreateTime:         txTime.Format(time.RFC3339),
+		ExpiryTime:         txTime.Add(reservationLifetime).Format(time.RFC3339),
+		State:              ReservationStateActive,
+		OrderId:            0,
+	}
+	detailsJSON, err := json.Marshal(ReservationPrivateDetails{
+		ReservationId: reservation.ReservationId,
+		CustomerId:    customer.CustomerId,
+		Price:         quote.Price,
+		QuoteTime:     quote.QuoteTime,
+	})
+	if err != nil {
+		return failure(err)
+	}
+	reservationIndexKey, err := ctx.GetStub().CreateCompositeKey(reservationIndexName, []string{reservation.ReservationId})
+	if err != nil {
+		return failure(err)
+	}
+	err = ctx.GetStub().PutPrivateData(reservation.PrivateCollection, reservationIndexKey, detailsJSON)
+	if err != nil {
+		return failure(fmt.Errorf("failed to put to private data collection %s. %v", reservation.PrivateCollection, err))
+	}
+	err = putReservation(ctx, &reservation)
+	if err != nil {
+		return failure(err)
+	}
+	err = putReservationExpiry(ctx, &reservation)
+	if err != nil {
+		return failure(err)
+	}
+	err = putCustomerReservation(ctx, reservation.PrivateCollection, customer.CustomerId, reservation.ReservationId)
+	if err != nil {
+		return failure(err)
+	}
+
+	return Result{
+		Code: 200,
+		Msg:  reservation.ReservationId,
+	}, nil
+}
+
+//ConfirmReservation converts an active reservation into an order at the reserved price,
+//only the client who reserved or the operator could confirm it.
+//Customer, goods value and salt are passed in the transient field orderDetails and dangerous goods declared as for CreateOrder.
+func (s *SmartContract) ConfirmReservation(ctx contractapi.TransactionContextInterface, reservationId string, totalTypeNum int,
+	goodsNumber []int, goodsName []string, unNumber []string, hazardClass []string) (Result, error) {
+	reservation, err := getReservation(ctx, reservationId)
+	if err != nil {
+		return failure(err)
+	}
+	if reservation.State != ReservationStateActive {
+		return failure(fmt.Errorf("the reservation %s is %s", reservationId, reservation.State))
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+	expired, err := reservationExpired(reservation, txTime)
+	if err != nil {
+		return failure(err)
+	}
+	if expired {
+		return failure(fmt.Errorf("the reservation %s expired at %s", reservationId, reservation.ExpiryTime))
+	}
+
+	holder, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return failure(fmt.Errorf("failed to get client's identity: %v", err))
+	}
+	if holder != reservation.Holder && assertOperator(ctx) != nil {
+		return failure(fmt.Errorf("the reservation %s could only be confirmed by its holder or the operator", reservationId))
+	}
+
+	details, err := getReservationPrivateDetails(ctx, reservation)
+	if err != nil {
+		return failure(err)
+	}
+
+	order, err := s.createOrder(ctx, reservation.TrainNumber, reservation.StartingStation, reservation.DestinationStation,
+		reservation.CarriageNumber, reservation.CarriageType, totalTypeNum, reservation.CargoType, goodsNumber, goodsName,
+		unNumber, hazardClass, reservation)
+	if err != nil {
+		return failure(err)
+	}
+
+	reservation.State = ReservationStateConfirmed
+	reservation.OrderId = order.OrderId
+	err = putReservation(ctx, reservation)
+	if err != nil {
+		return failure(err)
+	}
+	err = delReservationExpiry(ctx, reservation)
+	if err != nil {
+		return failure(err)
+	}
+	err = delCustomerReservation(ctx, reservation.PrivateCollection, details.CustomerId, reservationId)
+	if err != nil {
+		return failure(err)
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}, nil
+}
+
+//ExpireReservations expires all active reservations past their expiry time and releases their carriages.
+//Active reservations are read in order of expiry time from compositekey reservation~expiry up to the first unexpired one.
+func (s *SmartContract) ExpireReservations(ctx contractapi.TransactionContextInterface) (Result, error) {
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return failure(err)
+	}
+
+	expiryResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reservationexpiryIndexName, []string{})
+	if err != nil {
+		return failure(err)
+	}
+	defer expiryResultsIterator.Close()
+
+	var expiredReservations []*Reservation
+	for expiryResultsIterator.HasNext() {
+		expiryQueryResponse, err := expiryResultsIterator.Next()
+		if err != nil {
+			return failure(err)
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(expiryQueryResponse.Key)
+		if err != nil {
+			return failure(err)
+		}
+		reservation, err := getReservation(ctx, compositeKeyParts[1])
+		if err != nil {
+			return failure(err)
+		}
+		expired, err := reservationExpired(reservation, txTime)
+		if err != nil {
+			return failure(err)
+		}
+		if !expired {
+			break
+		}
+		if reservation.State == ReservationStateActive {
+			expiredReservations = append(expiredReservations, reservation)
+		}
+	}
+
+	for _, reservation := range expiredReservations {
+		err = releaseCarriages(ctx, reservation.TrainNumber, reservation.Carriages, 0, reservation.ReservationId)
+		if err != nil {
+			return failure(err)
+		}
+		reservation.State = ReservationStateExpired
+		err = putReservation(ctx, reservation)
+		if err != nil {
+			return failure(err)
+		}
+		err = delReservationExpiry(ctx, reservation)
+		if err != nil {
+			return failure(err)
+		}
+	}
+
+	return Result{
+		Code: 200,
+		Msg:  fmt.Sprintf("%d reservations expired", len(expiredReservations)),
+	}, nil
+}
+
+//QueryReservationByreservationid returns the reservation in the world state with given reservationId
+func (s *SmartContract) QueryReservationByreservationid(ctx contractapi.TransactionContextInterface, reservationId string) ReservationQueryResult {
+	reservation, err := getReservation(ctx, reservationId)
+	if err != nil {
+		return ReservationQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Reservation{Carriages: []int{}, CargoType: []string{}},
+		}
+	}
+	return ReservationQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *reservation,
+	}
+}
+
+//QueryReservationPrivateDetails returns the customer and quoted price of the reservation,
+//only members of the reservation's private data collection could read them
+func (s *SmartContract) QueryReservationPrivateDetails(ctx contractapi.TransactionContextInterface, reservationId string) ReservationPrivateDetailsQueryResult {
+	reservation, err := getReservation(ctx, reservationId)
+	if err != nil {
+		return ReservationPrivateDetailsQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ReservationPrivateDetails{},
+		}
+	}
+	details, err := getReservationPrivateDetails(ctx, reservation)
+	if err != nil {
+		return ReservationPrivateDetailsQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: ReservationPrivateDetails{},
+		}
+	}
+	return ReservationPrivateDetailsQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *details,
+	}
+}
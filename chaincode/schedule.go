@@ -15,11 +15,11 @@ var vehiclescheduleIndexName = "vehicle~schedule"
 
 //Schedule describes details of a schedule
 type Schedule struct {
-	ScheduleNumber int  `json:"scheduleNumber"`
-	LineNumber     int  `json:"lineNumber"`
-	VehicleNumber  int  `json:"vehicleNumber"`
-	UnitPrice      int  `json:"unitPrice"`
-	Using          bool `json:"using"`
+	ScheduleNumber int   `json:"scheduleNumber"`
+	LineNumber     int   `json:"lineNumber"`
+	VehicleNumber  int   `json:"vehicleNumber"`
+	UnitPrice      Money `json:"unitPrice"` //price of a carriage
+	Using          bool  `json:"using"`
 }
 
 type Schedules struct {
@@ -54,8 +54,49 @@ func (s *SmartContract) ScheduleExists(ctx contractapi.TransactionContextInterfa
 	return scheduleJSON != nil, nil
 }
 
-//CreateSchedule issues a new schedule to the world state with given details
-func (s *SmartContract) CreateSchedule(ctx contractapi.TransactionContextInterface, scheduleNumber, lineNumber, vehicleNumber, unitPrice int) Result {
+//getSchedule reads the schedule scheduleNumber from the world state
+func getSchedule(ctx contractapi.TransactionContextInterface, scheduleNumber int) (*Schedule, error) {
+	scheduleIndexKey, err := ctx.GetStub().CreateCompositeKey(scheduleIndexName, []string{strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return nil, err
+	}
+	scheduleJSON, err := ctx.GetStub().GetState(scheduleIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if scheduleJSON == nil {
+		return nil, fmt.Errorf("the schedule %d does not exist", scheduleNumber)
+	}
+
+	var schedule Schedule
+	err = json.Unmarshal(scheduleJSON, &schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+//putSchedule writes the schedule to the world state
+func putSchedule(ctx contractapi.TransactionContextInterface, schedule *Schedule) error {
+	scheduleIndexKey, err := ctx.GetStub().CreateCompositeKey(scheduleIndexName, []string{strconv.Itoa(schedule.ScheduleNumber)})
+	if err != nil {
+		return err
+	}
+	scheduleJSON, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(scheduleIndexKey, scheduleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//CreateSchedule issues a new schedule to the world state with given details,
+//unitPrice is the price of a carriage in minor units of currency
+func (s *SmartContract) CreateSchedule(ctx contractapi.TransactionContextInterface, scheduleNumber, lineNumber, vehicleNumber int,
+	unitPrice int64, currency string) Result {
 	scheduleIndexKey, err := ctx.GetStub().CreateCompositeKey(scheduleIndexName, []string{strconv.Itoa(scheduleNumber)})
 	if err != nil {
 		return Result{
@@ -78,6 +119,20 @@ func (s *SmartContract) CreateSchedule(ctx contractapi.TransactionContextInterfa
 		}
 	}
 
+	err = checkCurrency(currency)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if unitPrice < 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the unit price %d is negative", unitPrice),
+		}
+	}
+
 	//if the line lineNumber does not exist, the schedule couldn't be created
 	exists, err = s.LineExists(ctx, lineNumber)
 	if err != nil {
@@ -107,12 +162,27 @@ func (s *SmartContract) CreateSchedule(ctx contractapi.TransactionContextInterfa
 			Msg:  fmt.Sprintf("the vehicle %d does not exist", vehicleNumber),
 		}
 	}
+	//the vehicle and all of its wagons must be in service and the vehicle out of maintenance today
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkVehicleAvailable(ctx, vehicleNumber, txTime, txTime)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
 
 	schedule := Schedule{
 		ScheduleNumber: scheduleNumber,
 		LineNumber:     lineNumber,
 		VehicleNumber:  vehicleNumber,
-		UnitPrice:      unitPrice,
+		UnitPrice:      Money{Currency: currency, Amount: unitPrice},
 		Using:          true,
 	}
 	scheduleJSON, err := json.Marshal(schedule)
@@ -264,7 +334,7 @@ func (s *SmartContract) QueryScheduleByschedulenumber(ctx contractapi.Transactio
 				ScheduleNumber: 0,
 				LineNumber:     0,
 				VehicleNumber:  0,
-				UnitPrice:      0,
+				UnitPrice:      Money{},
 				Using:          false,
 			},
 		}
@@ -279,7 +349,7 @@ func (s *SmartContract) QueryScheduleByschedulenumber(ctx contractapi.Transactio
 				ScheduleNumber: 0,
 				LineNumber:     0,
 				VehicleNumber:  0,
-				UnitPrice:      0,
+				UnitPrice:      Money{},
 				Using:          false,
 			},
 		}
@@ -292,7 +362,7 @@ func (s *SmartContract) QueryScheduleByschedulenumber(ctx contractapi.Transactio
 				ScheduleNumber: 0,
 				LineNumber:     0,
 				VehicleNumber:  0,
-				UnitPrice:      0,
+				UnitPrice:      Money{},
 				Using:          false,
 			},
 		}
@@ -308,7 +378,7 @@ func (s *SmartContract) QueryScheduleByschedulenumber(ctx contractapi.Transactio
 				ScheduleNumber: 0,
 				LineNumber:     0,
 				VehicleNumber:  0,
-				UnitPrice:      0,
+				UnitPrice:      Money{},
 				Using:          false,
 			},
 		}
@@ -327,7 +397,7 @@ func (s *SmartContract) QueryAllSchedules(ctx contractapi.TransactionContextInte
 		ScheduleNumber: 0,
 		LineNumber:     0,
 		VehicleNumber:  0,
-		UnitPrice:      0,
+		UnitPrice:      Money{},
 		Using:          false,
 	})
 
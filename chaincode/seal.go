@@ -0,0 +1,464 @@
+//@author: hdsfade
+//@date: 2021-02-25-16:30
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strings"
+	"time"
+)
+
+var trainsealIndexName = "train~seal"
+var sealcheckIndexName = "sealcheck"
+
+//seal states
+const (
+	SealStateIntact   = "intact"   //完好
+	SealStateBroken   = "broken"   //破损
+	SealStateMissing  = "missing"  //缺失
+	SealStateReplaced = "replaced" //被更换
+	SealStateRemoved  = "removed"  //已拆除
+)
+
+//Seal describes a seal affixed to a container, or to a carriage if ContainerNumber is empty, on the train
+type Seal struct { //封条
+	SealNumber       string `json:"sealNumber"`
+	TrainNumber      string `json:"trainNumber"`
+	ContainerNumber  string `json:"containerNumber"`
+	CarriagePosition int    `json:"carriagePosition"` //0 if the seal is affixed to a container
+	OrderId          int    `json:"orderId"`
+	AffixStation     string `json:"affixStation"`
+	AffixTime        string `json:"affixTime"`
+	State            string `json:"state"`
+	StateStation     string `json:"stateStation"` //the station the state was found
+}
+
+//SealObservation describes a seal observed on a container or carriage by a cargo check
+type SealObservation struct {
+	SealNumber       string `json:"sealNumber"`
+	ContainerNumber  string `json:"containerNumber"`
+	CarriagePosition int    `json:"carriagePosition"`
+	Broken           bool   `json:"broken"`
+}
+
+//SealDiscrepancy describes a seal found broken, missing or replaced by a cargo check
+type SealDiscrepancy struct {
+	SealNumber       string `json:"sealNumber"`
+	ContainerNumber  string `json:"containerNumber"`
+	CarriagePosition int    `json:"carriagePosition"`
+	OrderId          int    `json:"orderId"`
+	State            string `json:"state"`
+	ObservedNumber   string `json:"observedNumber"` //the seal found instead of a replaced seal
+}
+
+//SealCheck describes the seals observed on the train by a cargo check at a station
+type SealCheck struct { //封条检查
+	TrainNumber   string            `json:"trainNumber"`
+	Sequence      int               `json:"sequence"`
+	StationName   string            `json:"stationName"`
+	Observed      []SealObservation `json:"observed"`
+	Discrepancies []SealDiscrepancy `json:"discrepancies"`
+	CheckTime     string            `json:"checkTime"`
+	Recorder      string            `json:"recorder"`
+}
+
+type Seals struct {
+	SealsData []Seal `json:"seals"`
+}
+
+type SealChecks struct {
+	ChecksData []SealCheck `json:"checks"`
+}
+
+//SealQueryResults structure used for handing result of query seals
+type SealQueryResults struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Seals  `json:"data"`
+}
+
+//SealCheckQueryResults structure used for handing result of query seal checks
+type SealCheckQueryResults struct {
+	Code int        `json:"code"`
+	Msg  string     `json:"msg"`
+	Data SealChecks `json:"data"`
+}
+
+//sealUnit describes the container or carriage the seal is affixed to
+func (seal *Seal) sealUnit() string {
+	if seal.ContainerNumber != "" {
+		return "container " + seal.ContainerNumber
+	}
+	return fmt.Sprintf("carriage %d", seal.CarriagePosition)
+}
+
+//observes judges the observation if is of the container or carriage the seal is affixed to or not
+func (observation *SealObservation) observes(seal *Seal) bool {
+	if seal.ContainerNumber != "" {
+		return observation.ContainerNumber == seal.ContainerNumber
+	}
+	return observation.ContainerNumber == "" && observation.CarriagePosition == seal.CarriagePosition
+}
+
+//putSeal writes the seal to the world state
+func putSeal(ctx contractapi.TransactionContextInterface, seal *Seal) error {
+	sealIndexKey, err := ctx.GetStub().CreateCompositeKey(trainsealIndexName, []string{seal.TrainNumber, seal.SealNumber})
+	if err != nil {
+		return err
+	}
+	sealJSON, err := json.Marshal(seal)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(sealIndexKey, sealJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//trainSeals returns all seals ever affixed on the train
+func trainSeals(ctx contractapi.TransactionContextInterface, trainNumber string) ([]Seal, error) {
+	sealResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(trainsealIndexName, []string{trainNumber})
+	if err != nil {
+		return nil, err
+	}
+	defer sealResultsIterator.Close()
+
+	seals := []Seal{}
+	for sealResultsIterator.HasNext() {
+		sealQueryResponse, err := sealResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var seal Seal
+		err = json.Unmarshal(sealQueryResponse.Value, &seal)
+		if err != nil {
+			return nil, err
+		}
+		seals = append(seals, seal)
+	}
+	return seals, nil
+}
+
+//checkNewSeals judges the seals sealNumbers if could be affixed on the train or not,
+//a seal number must not be empty, given twice or on the train already
+func checkNewSeals(ctx contractapi.TransactionContextInterface, trainNumber string, sealNumbers []string) error {
+	seals, err := trainSeals(ctx, trainNumber)
+	if err != nil {
+		return err
+	}
+	for i, sealNumber := range sealNumbers {
+		if sealNumber == "" {
+			return fmt.Errorf("the seal number is empty")
+		}
+		if containsString(sealNumbers[:i], sealNumber) {
+			return fmt.Errorf("the seal %s is given twice", sealNumber)
+		}
+		for _, seal := range seals {
+			if seal.SealNumber == sealNumber && seal.State != SealStateRemoved {
+				return fmt.Errorf("the seal %s is already on the %s of the train %s", sealNumber, seal.sealUnit(), trainNumber)
+			}
+		}
+	}
+	return nil
+}
+
+//affixSeals records the seals sealNumbers affixed at the station to the container containerNumber,
+//or to the carriage position if containerNumber is empty, of the order orderId on the train
+func affixSeals(ctx contractapi.TransactionContextInterface, trainNumber, containerNumber string, position, orderId int,
+	station string, sealNumbers []string) error {
+	err := checkNewSeals(ctx, trainNumber, sealNumbers)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sealNumber := range sealNumbers {
+		err = putSeal(ctx, &Seal{
+			SealNumber:       sealNumber,
+			TrainNumber:      trainNumber,
+			ContainerNumber:  containerNumber,
+			CarriagePosition: position,
+			OrderId:          orderId,
+			AffixStation:     station,
+			AffixTime:        txTime.Format(time.RFC3339),
+			State:            SealStateIntact,
+			StateStation:     station,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//removeContainerSeals records the seals of the container on the train removed at the station when it is unloaded
+func removeContainerSeals(ctx contractapi.TransactionContextInterface, trainNumber, containerNumber, station string) error {
+	seals, err := trainSeals(ctx, trainNumber)
+	if err != nil {
+		return err
+	}
+	for i := range seals {
+		seal := &seals[i]
+		//a seal found broken, missing or replaced keeps its state as evidence
+		if seal.ContainerNumber != containerNumber || seal.State != SealStateIntact {
+			continue
+		}
+		seal.State = SealStateRemoved
+		seal.StateStation = station
+		err = putSeal(ctx, seal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkSeals compares the seals observed by a cargo check at the train's current station with the intact seals
+//of the train and records the check. A seal not observed is missing, or replaced if another seal is observed on its
+//container or carriage, and a seal observed broken is broken. The seals' states are updated and the discrepancies returned.
+func (s *SmartContract) checkSeals(ctx contractapi.TransactionContextInterface, trainNumber string, observed []SealObservation) ([]SealDiscrepancy, error) {
+	seals, err := trainSeals(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(seals) == 0 && len(observed) == 0 {
+		return []SealDiscrepancy{}, nil
+	}
+	waybill, err := getWayBill(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	station := waybill.currentStation()
+
+	discrepancies := []SealDiscrepancy{}
+	offTrain := make(map[int]bool)
+	for i := range seals {
+		seal := &seals[i]
+		if seal.State != SealStateIntact {
+			continue
+		}
+		//seals of goods taken off the train, or of a deleted order, are no longer checked
+		if _, ok := offTrain[seal.OrderId]; !ok {
+			exists, err := s.OrderExists(ctx, seal.OrderId)
+			if err != nil {
+				return nil, err
+			}
+			offTrain[seal.OrderId] = !exists
+			if exists {
+				order, err := getOrder(ctx, seal.OrderId)
+				if err != nil {
+					return nil, err
+				}
+				offTrain[seal.OrderId] = orderOffTrain(order)
+			}
+		}
+		if offTrain[seal.OrderId] {
+			continue
+		}
+		var found, other *SealObservation
+		for j := range observed {
+			observation := &observed[j]
+			if !observation.observes(seal) {
+				continue
+			}
+			if observation.SealNumber == seal.SealNumber {
+				found = observation
+			} else if other == nil && !isTrainSeal(seals, observation.SealNumber) {
+				other = observation
+			}
+		}
+
+		discrepancy := SealDiscrepancy{
+			SealNumber:       seal.SealNumber,
+			ContainerNumber:  seal.ContainerNumber,
+			CarriagePosition: seal.CarriagePosition,
+			OrderId:          seal.OrderId,
+		}
+		if found != nil && !found.Broken {
+			continue
+		} else if found != nil {
+			discrepancy.State = SealStateBroken
+		} else if other != nil {
+			discrepancy.State = SealStateReplaced
+			discrepancy.ObservedNumber = other.SealNumber
+		} else {
+			discrepancy.State = SealStateMissing
+		}
+		discrepancies = append(discrepancies, discrepancy)
+
+		seal.State = discrepancy.State
+		seal.StateStation = station
+		err = putSeal(ctx, seal)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cargo, err := getCargo(ctx, trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client's MSPID: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if observed == nil {
+		observed = []SealObservation{}
+	}
+	check := SealCheck{
+		TrainNumber:   trainNumber,
+		Sequence:      len(cargo.StationCheckResult) + 1,
+		StationName:   station,
+		Observed:      observed,
+		Discrepancies: discrepancies,
+		CheckTime:     txTime.Format(time.RFC3339),
+		Recorder:      mspId,
+	}
+	checkIndexKey, err := ctx.GetStub().CreateCompositeKey(sealcheckIndexName, []string{trainNumber, fmt.Sprintf("%04d", check.Sequence)})
+	if err != nil {
+		return nil, err
+	}
+	checkJSON, err := json.Marshal(check)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(checkIndexKey, checkJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return discrepancies, nil
+}
+
+//isTrainSeal judges the seal number if is an intact seal of the train or not
+func isTrainSeal(seals []Seal, sealNumber string) bool {
+	for _, seal := range seals {
+		if seal.SealNumber == sealNumber && seal.State == SealStateIntact {
+			return true
+		}
+	}
+	return false
+}
+
+//describeSealDiscrepancies describes the discrepancies as a hold reason
+func describeSealDiscrepancies(discrepancies []SealDiscrepancy) string {
+	descriptions := []string{}
+	for _, discrepancy := range discrepancies {
+		description := fmt.Sprintf("seal %s %s", discrepancy.SealNumber, discrepancy.State)
+		if discrepancy.ObservedNumber != "" {
+			description += " by " + discrepancy.ObservedNumber
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+//AffixCarriageSeals records the seals sealNumbers affixed at the station to the carriage position of the train,
+//the carriage must be booked by an order
+func (s *SmartContract) AffixCarriageSeals(ctx contractapi.TransactionContextInterface, trainNumber string, position int,
+	station string, sealNumbers []string) Result {
+	carriage, err := getCarriage(ctx, trainNumber, position)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if carriage.Status != CarriageStatusBooked {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the carriage %d of the train %s is %s, only a booked carriage could be sealed", position, trainNumber, carriage.Status),
+		}
+	}
+	if len(sealNumbers) == 0 {
+		return Result{
+			Code: 402,
+			Msg:  "no seal number",
+		}
+	}
+
+	err = affixSeals(ctx, trainNumber, "", position, carriage.OrderId, station, sealNumbers)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QuerySealsBytrainnumber returns all seals affixed on the train with their states
+func (s *SmartContract) QuerySealsBytrainnumber(ctx contractapi.TransactionContextInterface, trainNumber string) SealQueryResults {
+	seals, err := trainSeals(ctx, trainNumber)
+	if err != nil {
+		return SealQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Seals{SealsData: []Seal{}},
+		}
+	}
+	if len(seals) == 0 {
+		return SealQueryResults{
+			Code: 402,
+			Msg:  fmt.Sprintf("the train %s has no seal", trainNumber),
+			Data: Seals{SealsData: []Seal{}},
+		}
+	}
+	return SealQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Seals{SealsData: seals},
+	}
+}
+
+//QuerySealChecks returns the seals observed by all cargo checks of the train in order
+func (s *SmartContract) QuerySealChecks(ctx contractapi.TransactionContextInterface, trainNumber string) SealCheckQueryResults {
+	checkResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(sealcheckIndexName, []string{trainNumber})
+	if err != nil {
+		return SealCheckQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: SealChecks{ChecksData: []SealCheck{}},
+		}
+	}
+	defer checkResultsIterator.Close()
+
+	checks := []SealCheck{}
+	for checkResultsIterator.HasNext() {
+		checkQueryResponse, err := checkResultsIterator.Next()
+		if err != nil {
+			return SealCheckQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: SealChecks{ChecksData: []SealCheck{}},
+			}
+		}
+		var check SealCheck
+		err = json.Unmarshal(checkQueryResponse.Value, &check)
+		if err != nil {
+			return SealCheckQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: SealChecks{ChecksData: []SealCheck{}},
+			}
+		}
+		checks = append(checks, check)
+	}
+	return SealCheckQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: SealChecks{ChecksData: checks},
+	}
+}
@@ -0,0 +1,483 @@
+//@author: hdsfade
+//@date: 2021-02-18-15:20
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
+)
+
+var tariffIndexName = "tariff"
+
+//TariffRule describes the price of a carriage on a segment of the line, between two consecutive way stations,
+//a rule without cargo type applies to every cargo type and an order pays the rule of every segment it runs
+type TariffRule struct {
+	FromStation   string `json:"fromStation"`
+	ToStation     string `json:"toStation"`
+	CargoType     string `json:"cargoType"`
+	CarriagePrice int64  `json:"carriagePrice"` //in minor units of the tariff's currency
+}
+
+//SeasonSurcharge describes a surcharge (or a discount if Percent is negative) of trains departing from FromDate to ToDate,
+//dates are formatted as 01-02 and the season may span the new year
+type SeasonSurcharge struct {
+	FromDate string `json:"fromDate"`
+	ToDate   string `json:"toDate"`
+	Percent  int    `json:"percent"`
+}
+
+//QuantityBreak describes the discount of orders of at least MinCarriages carriages
+type QuantityBreak struct {
+	MinCarriages    int `json:"minCarriages"`
+	DiscountPercent int `json:"discountPercent"`
+}
+
+//Tariff describes the prices of a line, or of a schedule of the line if ScheduleNumber is not 0
+type Tariff struct { //运价
+	LineNumber       int               `json:"lineNumber"`
+	ScheduleNumber   int               `json:"scheduleNumber"`
+	Currency         string            `json:"currency"`
+	Rules            []TariffRule      `json:"rules"`
+	SeasonSurcharges []SeasonSurcharge `json:"seasonSurcharges"`
+	QuantityBreaks   []QuantityBreak   `json:"quantityBreaks"`
+}
+
+//Quote describes the price of an order, Total is in the currency of the tariff and Price in the quoted currency
+type Quote struct { //报价
+	TrainNumber        string   `json:"trainNumber"`
+	StartingStation    string   `json:"startingStation"`
+	DestinationStation string   `json:"destinationStation"`
+	CarriageNumber     int      `json:"carriageNumber"`
+	CargoType          []string `json:"cargoType"`
+	LineNumber         int      `json:"lineNumber"`
+	ScheduleNumber     int      `json:"scheduleNumber"`
+	Source             string   `json:"source"` //tariff of the line or schedule, or unit price of the schedule
+	Base               Money    `json:"base"`
+	SeasonSurcharge    Money    `json:"seasonSurcharge"`
+	QuantityDiscount   Money    `json:"quantityDiscount"`
+	Total              Money    `json:"total"`
+	Price              Money    `json:"price"`
+	QuoteTime          string   `json:"quoteTime"`
+}
+
+//TariffQueryResult structure used for handing result of query
+type TariffQueryResult struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Tariff `json:"data"`
+}
+
+//QuoteQueryResult structure used for handing result of quote
+type QuoteQueryResult struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Quote  `json:"data"`
+}
+
+//sources of quotes
+const (
+	QuoteSourceLineTariff     = "linetariff"
+	QuoteSourceScheduleTariff = "scheduletariff"
+	QuoteSourceUnitPrice      = "unitprice"
+)
+
+//percentOf returns percent percent of amount, rounded half away from zero
+func percentOf(amount int64, percent int) int64 {
+	value := amount * int64(percent)
+	if value < 0 {
+		return -((-value + 50) / 100)
+	}
+	return (value + 50) / 100
+}
+
+//getTariff reads the tariff of the line's schedule (0 for the line) from the world state, nil if there is none
+func getTariff(ctx contractapi.TransactionContextInterface, lineNumber, scheduleNumber int) (*Tariff, error) {
+	tariffIndexKey, err := ctx.GetStub().CreateCompositeKey(tariffIndexName, []string{strconv.Itoa(lineNumber), strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return nil, err
+	}
+	tariffJSON, err := ctx.GetStub().GetState(tariffIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if tariffJSON == nil {
+		return nil, nil
+	}
+
+	var tariff Tariff
+	err = json.Unmarshal(tariffJSON, &tariff)
+	if err != nil {
+		return nil, err
+	}
+	return &tariff, nil
+}
+
+//checkTariff judges the tariff if valid for the line or not
+func checkTariff(tariff *Tariff, line *Line) error {
+	err := checkCurrency(tariff.Currency)
+	if err != nil {
+		return err
+	}
+	if len(tariff.Rules) == 0 {
+		return fmt.Errorf("the tariff has no rule")
+	}
+	for _, rule := range tariff.Rules {
+		from, to := line.stationIndex(rule.FromStation), line.stationIndex(rule.ToStation)
+		if from < 0 || to < 0 || from >= to {
+			return fmt.Errorf("the line %d doesn't run from %s to %s", line.LineNumber, rule.FromStation, rule.ToStation)
+		}
+		//the price is charged for every segment, so a rule of several segments would be charged several times
+		if to != from+1 {
+			return fmt.Errorf("the rule from %s to %s isn't a segment of the line %d, its price is per segment",
+				rule.FromStation, rule.ToStation, line.LineNumber)
+		}
+		if rule.CarriagePrice < 0 {
+			return fmt.Errorf("the carriage price %d from %s to %s is negative", rule.CarriagePrice, rule.FromStation, rule.ToStation)
+		}
+	}
+	for _, season := range tariff.SeasonSurcharges {
+		_, err = time.Parse("01-02", season.FromDate)
+		if err == nil {
+			_, err = time.Parse("01-02", season.ToDate)
+		}
+		if err != nil {
+			return fmt.Errorf("season error: %v", err)
+		}
+		if season.Percent <= -100 {
+			return fmt.Errorf("the season surcharge %d%% is not more than -100%%", season.Percent)
+		}
+	}
+	for _, quantityBreak := range tariff.QuantityBreaks {
+		if quantityBreak.MinCarriages <= 0 {
+			return fmt.Errorf("the quantity break's minimum carriages %d must be positive", quantityBreak.MinCarriages)
+		}
+		if quantityBreak.DiscountPercent < 0 || quantityBreak.DiscountPercent >= 100 {
+			return fmt.Errorf("the quantity discount %d%% must be from 0%% to 99%%", quantityBreak.DiscountPercent)
+		}
+	}
+	return nil
+}
+
+//segmentPrice returns the carriage price of the segment from the location-th station of the line to the next one,
+//the highest price of the cargo types applies. A rule of a cargo type takes precedence over rules without cargo type,
+//and a later rule takes precedence over earlier ones.
+func (tariff *Tariff) segmentPrice(line *Line, location int, cargoType []string) (int64, error) {
+	if len(cargoType) == 0 {
+		cargoType = []string{""}
+	}
+	var price int64
+	for _, typ := range cargoType {
+		var general, specific *TariffRule
+		for i := range tariff.Rules {
+			rule := &tariff.Rules[i]
+			if line.stationIndex(rule.FromStation) > location || line.stationIndex(rule.ToStation) <= location {
+				continue
+			}
+			if rule.CargoType == "" {
+				general = rule
+			} else if rule.CargoType == typ {
+				specific = rule
+			}
+		}
+		if specific == nil {
+			specific = general
+		}
+		if specific == nil {
+			return 0, fmt.Errorf("the tariff of the line %d has no price from %s to %s for cargo type %s",
+				line.LineNumber, line.WayStation[location], line.WayStation[location+1], typ)
+		}
+		if specific.CarriagePrice > price {
+			price = specific.CarriagePrice
+		}
+	}
+	return price, nil
+}
+
+//seasonPercent returns the surcharge percent of trains departing on the date, the first matching season applies
+func (tariff *Tariff) seasonPercent(departure time.Time) int {
+	date := departure.Format("01-02")
+	for _, season := range tariff.SeasonSurcharges {
+		if season.FromDate <= season.ToDate {
+			if season.FromDate <= date && date <= season.ToDate {
+				return season.Percent
+			}
+		} else if date >= season.FromDate || date <= season.ToDate {
+			return season.Percent
+		}
+	}
+	return 0
+}
+
+//quantityDiscountPercent returns the discount percent of carriageNumber carriages, the largest reached break applies
+func (tariff *Tariff) quantityDiscountPercent(carriageNumber int) int {
+	minCarriages, percent := 0, 0
+	for _, quantityBreak := range tariff.QuantityBreaks {
+		if quantityBreak.MinCarriages <= carriageNumber && quantityBreak.MinCarriages > minCarriages {
+			minCarriages, percent = quantityBreak.MinCarriages, quantityBreak.DiscountPercent
+		}
+	}
+	return percent
+}
+
+//quoteOrder prices carriageNumber carriages of the train from startingStation to destinationStation in currency
+//(the tariff's currency if currency is empty). The tariff of the train's schedule is used, then the tariff of its line,
+//and the schedule's unit price if the line has no tariff.
+func quoteOrder(ctx contractapi.TransactionContextInterface, trainNumber, startingStation, destinationStation string,
+	carriageNumber int, cargoType []string, currency string) (*Quote, error) {
+	if carriageNumber <= 0 {
+		return nil, fmt.Errorf("the carriage number %d must be positive", carriageNumber)
+	}
+	scheduleNumber, err := trainScheduleNumber(trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	departure, err := trainDepartureDate(trainNumber)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := getSchedule(ctx, scheduleNumber)
+	if err != nil {
+		return nil, err
+	}
+	line, err := getLine(ctx, schedule.LineNumber)
+	if err != nil {
+		return nil, err
+	}
+	from, to := line.stationIndex(startingStation), line.stationIndex(destinationStation)
+	if from < 0 || to < 0 || from >= to {
+		return nil, fmt.Errorf("the line %d doesn't run from %s to %s", line.LineNumber, startingStation, destinationStation)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := Quote{
+		TrainNumber:        trainNumber,
+		StartingStation:    startingStation,
+		DestinationStation: destinationStation,
+		CarriageNumber:     carriageNumber,
+		CargoType:          cargoType,
+		LineNumber:         line.LineNumber,
+		ScheduleNumber:     scheduleNumber,
+		QuoteTime:          txTime.Format(time.RFC3339),
+	}
+	if quote.CargoType == nil {
+		quote.CargoType = []string{}
+	}
+
+	tariff, err := getTariff(ctx, line.LineNumber, scheduleNumber)
+	if err != nil {
+		return nil, err
+	}
+	quote.Source = QuoteSourceScheduleTariff
+	if tariff == nil {
+		tariff, err = getTariff(ctx, line.LineNumber, 0)
+		if err != nil {
+			return nil, err
+		}
+		quote.Source = QuoteSourceLineTariff
+	}
+
+	if tariff == nil {
+		quote.Source = QuoteSourceUnitPrice
+		quote.Base = Money{Currency: schedule.UnitPrice.Currency, Amount: schedule.UnitPrice.Amount * int64(carriageNumber)}
+		quote.SeasonSurcharge = Money{Currency: quote.Base.Currency}
+		quote.QuantityDiscount = Money{Currency: quote.Base.Currency}
+		quote.Total = quote.Base
+	} else {
+		quote.Base = Money{Currency: tariff.Currency}
+		for location := from; location < to; location++ {
+			price, err := tariff.segmentPrice(line, location, cargoType)
+			if err != nil {
+				return nil, err
+			}
+			quote.Base.Amount += price * int64(carriageNumber)
+		}
+		quote.SeasonSurcharge = Money{Currency: tariff.Currency, Amount: percentOf(quote.Base.Amount, tariff.seasonPercent(departure))}
+		subtotal := quote.Base.Amount + quote.SeasonSurcharge.Amount
+		quote.QuantityDiscount = Money{Currency: tariff.Currency, Amount: percentOf(subtotal, tariff.quantityDiscountPercent(carriageNumber))}
+		quote.Total = Money{Currency: tariff.Currency, Amount: subtotal - quote.QuantityDiscount.Amount}
+	}
+
+	if currency == "" {
+		currency = quote.Total.Currency
+	}
+	quote.Price, err = convertMoney(ctx, quote.Total, currency, txTime)
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+//SetTariff issues or replaces the tariff of the line, or of the line's schedule scheduleNumber if it is not 0.
+//Only the operator could set tariffs.
+func (s *SmartContract) SetTariff(ctx contractapi.TransactionContextInterface, lineNumber, scheduleNumber int, currency string,
+	rules []TariffRule, seasonSurcharges []SeasonSurcharge, quantityBreaks []QuantityBreak) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	line, err := getLine(ctx, lineNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if scheduleNumber != 0 {
+		schedule, err := getSchedule(ctx, scheduleNumber)
+		if err != nil {
+			return Result{
+				Code: 402,
+				Msg:  err.Error(),
+			}
+		}
+		if schedule.LineNumber != lineNumber {
+			return Result{
+				Code: 402,
+				Msg:  fmt.Sprintf("the schedule %d runs on the line %d, not %d", scheduleNumber, schedule.LineNumber, lineNumber),
+			}
+		}
+	}
+
+	tariff := Tariff{
+		LineNumber:       lineNumber,
+		ScheduleNumber:   scheduleNumber,
+		Currency:         currency,
+		Rules:            rules,
+		SeasonSurcharges: seasonSurcharges,
+		QuantityBreaks:   quantityBreaks,
+	}
+	if tariff.SeasonSurcharges == nil {
+		tariff.SeasonSurcharges = []SeasonSurcharge{}
+	}
+	if tariff.QuantityBreaks == nil {
+		tariff.QuantityBreaks = []QuantityBreak{}
+	}
+	err = checkTariff(&tariff, line)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	tariffJSON, err := json.Marshal(tariff)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	tariffIndexKey, err := ctx.GetStub().CreateCompositeKey(tariffIndexName, []string{strconv.Itoa(lineNumber), strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = ctx.GetStub().PutState(tariffIndexKey, tariffJSON)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//DeleteTariff deletes the tariff of the line, or of the line's schedule scheduleNumber if it is not 0
+func (s *SmartContract) DeleteTariff(ctx contractapi.TransactionContextInterface, lineNumber, scheduleNumber int) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	tariff, err := getTariff(ctx, lineNumber, scheduleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if tariff == nil {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the tariff of line %d schedule %d does not exist", lineNumber, scheduleNumber),
+		}
+	}
+
+	tariffIndexKey, err := ctx.GetStub().CreateCompositeKey(tariffIndexName, []string{strconv.Itoa(lineNumber), strconv.Itoa(scheduleNumber)})
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = ctx.GetStub().DelState(tariffIndexKey)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QueryTariff returns the tariff of the line, or of the line's schedule scheduleNumber if it is not 0
+func (s *SmartContract) QueryTariff(ctx contractapi.TransactionContextInterface, lineNumber, scheduleNumber int) TariffQueryResult {
+	emptyTariff := Tariff{Rules: []TariffRule{}, SeasonSurcharges: []SeasonSurcharge{}, QuantityBreaks: []QuantityBreak{}}
+	tariff, err := getTariff(ctx, lineNumber, scheduleNumber)
+	if err != nil {
+		return TariffQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: emptyTariff,
+		}
+	}
+	if tariff == nil {
+		return TariffQueryResult{
+			Code: 402,
+			Msg:  fmt.Sprintf("the tariff of line %d schedule %d does not exist", lineNumber, scheduleNumber),
+			Data: emptyTariff,
+		}
+	}
+	return TariffQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *tariff,
+	}
+}
+
+//QuoteOrder prices an order without creating it, the price is converted into currency if it is not empty
+func (s *SmartContract) QuoteOrder(ctx contractapi.TransactionContextInterface, trainNumber, startingStation, destinationStation string,
+	carriageNumber int, cargoType []string, currency string) QuoteQueryResult {
+	quote, err := quoteOrder(ctx, trainNumber, startingStation, destinationStation, carriageNumber, cargoType, currency)
+	if err != nil {
+		return QuoteQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Quote{CargoType: []string{}},
+		}
+	}
+	return QuoteQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *quote,
+	}
+}
@@ -0,0 +1,505 @@
+//@author: hdsfade
+//@date: 2021-02-26-15:10
+package chaincode
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var ordertelemetryIndexName = "order~telemetry"
+var orderexcursionIndexName = "order~excursion"
+
+//telemetryGatewayRole is the value of the role attribute of IoT gateway identities allowed to submit sensor readings
+var telemetryGatewayRole = "iotgateway"
+
+//SensorThreshold describes the range readings of a sensor type must stay in for an order, e.g. temperature in °C
+type SensorThreshold struct {
+	SensorType string  `json:"sensorType"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+}
+
+//SensorReading describes a reading of a sensor in a container or carriage
+type SensorReading struct {
+	SensorId   string  `json:"sensorId"`
+	SensorType string  `json:"sensorType"`
+	Time       string  `json:"time"` //RFC3339
+	Value      float64 `json:"value"`
+}
+
+//TelemetryBatch describes a batch of readings submitted by an IoT gateway for the goods of an order,
+//either the readings themselves or the Merkle root of readings kept off the ledger
+type TelemetryBatch struct { //传感器数据批次
+	OrderId          int             `json:"orderId"`
+	TrainNumber      string          `json:"trainNumber"`
+	ContainerNumber  string          `json:"containerNumber"`
+	CarriagePosition int             `json:"carriagePosition"` //0 if the readings are of a container
+	TxId             string          `json:"txId"`
+	Gateway          string          `json:"gateway"` //identity of the submitting gateway
+	SubmitTime       string          `json:"submitTime"`
+	FromTime         string          `json:"fromTime"`
+	ToTime           string          `json:"toTime"`
+	ReadingCount     int             `json:"readingCount"`
+	Readings         []SensorReading `json:"readings,omitempty" metadata:",optional"`
+	MerkleRoot       string          `json:"merkleRoot,omitempty" metadata:",optional"` //hex encoded sha256
+	Excursions       int             `json:"excursions"`
+}
+
+//Excursion describes a reading out of the range of the order's threshold
+type Excursion struct { //超限记录
+	OrderId          int     `json:"orderId"`
+	TrainNumber      string  `json:"trainNumber"`
+	ContainerNumber  string  `json:"containerNumber"`
+	CarriagePosition int     `json:"carriagePosition"`
+	SensorId         string  `json:"sensorId"`
+	SensorType       string  `json:"sensorType"`
+	ReadingTime      string  `json:"readingTime"`
+	Value            float64 `json:"value"`
+	Min              float64 `json:"min"`
+	Max              float64 `json:"max"`
+	BatchTxId        string  `json:"batchTxId"`
+}
+
+type TelemetryBatches struct {
+	BatchesData []TelemetryBatch `json:"batches"`
+}
+
+type Excursions struct {
+	ExcursionsData []Excursion `json:"excursions"`
+}
+
+//TelemetryQueryResults structure used for handing result of query telemetry batches
+type TelemetryQueryResults struct {
+	Code int              `json:"code"`
+	Msg  string           `json:"msg"`
+	Data TelemetryBatches `json:"data"`
+}
+
+//ExcursionQueryResults structure used for handing result of query excursions
+type ExcursionQueryResults struct {
+	Code int        `json:"code"`
+	Msg  string     `json:"msg"`
+	Data Excursions `json:"data"`
+}
+
+//checkThresholds judges the thresholds if valid or not, every sensor type has one range at most
+func checkThresholds(thresholds []SensorThreshold) error {
+	for i, threshold := range thresholds {
+		if threshold.SensorType == "" {
+			return fmt.Errorf("the sensor type of the threshold %d is empty", i)
+		}
+		if threshold.Min >= threshold.Max {
+			return fmt.Errorf("the minimum %g of the sensor type %s is not less than the maximum %g",
+				threshold.Min, threshold.SensorType, threshold.Max)
+		}
+		for _, other := range thresholds[:i] {
+			if other.SensorType == threshold.SensorType {
+				return fmt.Errorf("the sensor type %s has more than one threshold", threshold.SensorType)
+			}
+		}
+	}
+	return nil
+}
+
+//checkReadings judges the readings if valid or not and returns the time of the earliest and the latest reading,
+//the time of every reading is formatted in UTC
+func checkReadings(readings []SensorReading) (time.Time, time.Time, error) {
+	var from, to time.Time
+	for i, reading := range readings {
+		if reading.SensorId == "" || reading.SensorType == "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("the sensor or sensor type of the reading %d is empty", i)
+		}
+		readingTime, err := time.Parse(time.RFC3339, reading.Time)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("the time of the reading %d: %v", i, err)
+		}
+		readingTime = readingTime.UTC()
+		readings[i].Time = readingTime.Format(time.RFC3339)
+		if i == 0 || readingTime.Before(from) {
+			from = readingTime
+		}
+		if i == 0 || readingTime.After(to) {
+			to = readingTime
+		}
+	}
+	return from, to, nil
+}
+
+//telemetryOrderId returns the order the container containerNumber on the train is loaded for,
+//or the order the carriage position of the train is booked by if containerNumber is empty
+func telemetryOrderId(ctx contractapi.TransactionContextInterface, trainNumber, containerNumber string, position int) (int, error) {
+	if containerNumber != "" {
+		container, err := getContainer(ctx, containerNumber)
+		if err != nil {
+			return 0, err
+		}
+		if container.TrainNumber != trainNumber {
+			return 0, fmt.Errorf("the container %s is not loaded on the train %s", containerNumber, trainNumber)
+		}
+		return container.OrderId, nil
+	}
+
+	carriage, err := getCarriage(ctx, trainNumber, position)
+	if err != nil {
+		return 0, err
+	}
+	if carriage.Status != CarriageStatusBooked {
+		return 0, fmt.Errorf("the carriage %d of the train %s is %s, not booked by an order", position, trainNumber, carriage.Status)
+	}
+	return carriage.OrderId, nil
+}
+
+//recordTelemetryBatch checks the readings against the thresholds of the batch's order, records an excursion
+//for every reading out of range and writes the batch, the readings are checked but not kept if the batch is anchored
+func recordTelemetryBatch(ctx contractapi.TransactionContextInterface, batch *TelemetryBatch, readings []SensorReading) error {
+	order, err := getOrder(ctx, batch.OrderId)
+	if err != nil {
+		return err
+	}
+	gateway, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client's identity: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	batch.TxId = ctx.GetStub().GetTxID()
+	batch.Gateway = gateway
+	batch.SubmitTime = txTime.Format(time.RFC3339)
+
+	for _, reading := range readings {
+		for _, threshold := range order.Thresholds {
+			if threshold.SensorType != reading.SensorType || (reading.Value >= threshold.Min && reading.Value <= threshold.Max) {
+				continue
+			}
+			excursion := Excursion{
+				OrderId:          batch.OrderId,
+				TrainNumber:      batch.TrainNumber,
+				ContainerNumber:  batch.ContainerNumber,
+				CarriagePosition: batch.CarriagePosition,
+				SensorId:         reading.SensorId,
+				SensorType:       reading.SensorType,
+				ReadingTime:      reading.Time,
+				Value:            reading.Value,
+				Min:              threshold.Min,
+				Max:              threshold.Max,
+				BatchTxId:        batch.TxId,
+			}
+			excursionIndexKey, err := ctx.GetStub().CreateCompositeKey(orderexcursionIndexName,
+				[]string{strconv.Itoa(batch.OrderId), batch.TxId, fmt.Sprintf("%04d", batch.Excursions)})
+			if err != nil {
+				return err
+			}
+			excursionJSON, err := json.Marshal(excursion)
+			if err != nil {
+				return err
+			}
+			err = ctx.GetStub().PutState(excursionIndexKey, excursionJSON)
+			if err != nil {
+				return fmt.Errorf("failed to put to world state. %v", err)
+			}
+			batch.Excursions++
+		}
+	}
+
+	//batches are keyed by transaction so that gateways of the same order don't conflict
+	batchIndexKey, err := ctx.GetStub().CreateCompositeKey(ordertelemetryIndexName,
+		[]string{strconv.Itoa(batch.OrderId), batch.TxId})
+	if err != nil {
+		return err
+	}
+	batchJSON, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(batchIndexKey, batchJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//telemetryResult returns the result of a recorded batch with the number of excursions in msg
+func telemetryResult(batch *TelemetryBatch) Result {
+	if batch.Excursions > 0 {
+		return Result{
+			Code: 200,
+			Msg:  fmt.Sprintf("%d excursions recorded for the order %d", batch.Excursions, batch.OrderId),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//SetOrderThresholds sets the ranges sensor readings of the order's goods must stay in, only by the operator
+func (s *SmartContract) SetOrderThresholds(ctx contractapi.TransactionContextInterface, orderId int, thresholds []SensorThreshold) Result {
+	err := assertOperator(ctx)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = checkThresholds(thresholds)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	order, err := getOrder(ctx, orderId)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	order.Thresholds = thresholds
+	err = putOrder(ctx, order)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//SubmitSensorReadings records a batch of readings of the container containerNumber on the train,
+//or of the carriage position if containerNumber is empty, and an excursion for every reading out of
+//the thresholds of the order. Only identities with the role attribute iotgateway could submit readings.
+func (s *SmartContract) SubmitSensorReadings(ctx contractapi.TransactionContextInterface, trainNumber, containerNumber string,
+	position int, readings []SensorReading) Result {
+	err := assertRole(ctx, telemetryGatewayRole)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if len(readings) == 0 {
+		return Result{
+			Code: 402,
+			Msg:  "no reading",
+		}
+	}
+	from, to, err := checkReadings(readings)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	orderId, err := telemetryOrderId(ctx, trainNumber, containerNumber, position)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	batch := TelemetryBatch{
+		OrderId:          orderId,
+		TrainNumber:      trainNumber,
+		ContainerNumber:  containerNumber,
+		CarriagePosition: position,
+		FromTime:         from.Format(time.RFC3339),
+		ToTime:           to.Format(time.RFC3339),
+		ReadingCount:     len(readings),
+		Readings:         readings,
+	}
+	err = recordTelemetryBatch(ctx, &batch, readings)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return telemetryResult(&batch)
+}
+
+//AnchorSensorBatch records the Merkle root of readingCount readings taken from fromTime to toTime (RFC3339)
+//of the container containerNumber on the train, or of the carriage position if containerNumber is empty.
+//The readings stay off the ledger, the gateway passes the lowest and highest readings of every sensor
+//in extremes so that excursions are recorded as for SubmitSensorReadings.
+func (s *SmartContract) AnchorSensorBatch(ctx contractapi.TransactionContextInterface, trainNumber, containerNumber string,
+	position int, merkleRoot string, readingCount int, fromTime, toTime string, extremes []SensorReading) Result {
+	err := assertRole(ctx, telemetryGatewayRole)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	root, err := hex.DecodeString(merkleRoot)
+	if err != nil || len(root) != 32 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the Merkle root %s is not a hex encoded sha256", merkleRoot),
+		}
+	}
+	if readingCount <= 0 || len(extremes) > readingCount {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the batch has %d readings but %d extremes", readingCount, len(extremes)),
+		}
+	}
+	from, err := time.Parse(time.RFC3339, fromTime)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	to, err := time.Parse(time.RFC3339, toTime)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if to.Before(from) {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the batch ends at %s before it starts at %s", toTime, fromTime),
+		}
+	}
+	extremeFrom, extremeTo, err := checkReadings(extremes)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if len(extremes) > 0 && (extremeFrom.Before(from) || extremeTo.After(to)) {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("an extreme reading is out of the batch from %s to %s", fromTime, toTime),
+		}
+	}
+	orderId, err := telemetryOrderId(ctx, trainNumber, containerNumber, position)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+
+	batch := TelemetryBatch{
+		OrderId:          orderId,
+		TrainNumber:      trainNumber,
+		ContainerNumber:  containerNumber,
+		CarriagePosition: position,
+		FromTime:         from.UTC().Format(time.RFC3339),
+		ToTime:           to.UTC().Format(time.RFC3339),
+		ReadingCount:     readingCount,
+		MerkleRoot:       hex.EncodeToString(root),
+	}
+	err = recordTelemetryBatch(ctx, &batch, extremes)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return telemetryResult(&batch)
+}
+
+//QueryOrderTelemetry returns all telemetry batches of the order in the order of their readings' time
+func (s *SmartContract) QueryOrderTelemetry(ctx contractapi.TransactionContextInterface, orderId int) TelemetryQueryResults {
+	batchResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(ordertelemetryIndexName, []string{strconv.Itoa(orderId)})
+	if err != nil {
+		return TelemetryQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: TelemetryBatches{BatchesData: []TelemetryBatch{}},
+		}
+	}
+	defer batchResultsIterator.Close()
+
+	batches := []TelemetryBatch{}
+	for batchResultsIterator.HasNext() {
+		batchQueryResponse, err := batchResultsIterator.Next()
+		if err != nil {
+			return TelemetryQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: TelemetryBatches{BatchesData: []TelemetryBatch{}},
+			}
+		}
+		var batch TelemetryBatch
+		err = json.Unmarshal(batchQueryResponse.Value, &batch)
+		if err != nil {
+			return TelemetryQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: TelemetryBatches{BatchesData: []TelemetryBatch{}},
+			}
+		}
+		batches = append(batches, batch)
+	}
+	sort.SliceStable(batches, func(i, j int) bool {
+		return batches[i].FromTime < batches[j].FromTime
+	})
+	return TelemetryQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: TelemetryBatches{BatchesData: batches},
+	}
+}
+
+//QueryOrderExcursions returns all excursions of the order in the order of their readings' time
+func (s *SmartContract) QueryOrderExcursions(ctx contractapi.TransactionContextInterface, orderId int) ExcursionQueryResults {
+	excursionResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderexcursionIndexName, []string{strconv.Itoa(orderId)})
+	if err != nil {
+		return ExcursionQueryResults{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Excursions{ExcursionsData: []Excursion{}},
+		}
+	}
+	defer excursionResultsIterator.Close()
+
+	excursions := []Excursion{}
+	for excursionResultsIterator.HasNext() {
+		excursionQueryResponse, err := excursionResultsIterator.Next()
+		if err != nil {
+			return ExcursionQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Excursions{ExcursionsData: []Excursion{}},
+			}
+		}
+		var excursion Excursion
+		err = json.Unmarshal(excursionQueryResponse.Value, &excursion)
+		if err != nil {
+			return ExcursionQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Excursions{ExcursionsData: []Excursion{}},
+			}
+		}
+		excursions = append(excursions, excursion)
+	}
+	sort.SliceStable(excursions, func(i, j int) bool {
+		return excursions[i].ReadingTime < excursions[j].ReadingTime
+	})
+	return ExcursionQueryResults{
+		Code: 200,
+		Msg:  "success",
+		Data: Excursions{ExcursionsData: excursions},
+	}
+}
@@ -6,15 +6,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"time"
 )
 
 var trainIndexName = "train"
 var trainOrderIndexName = "train~order"
 
-//Train describe details of a train
+//Train describe details of a train.
+//Every carriage is a key train~carriage of its own, so that bookings of different carriages don't conflict,
+//and CarriageLeft is computed from them when the train is queried.
+//The vehicle runs the train from the departure date to ArrivalDate, see VehicleAssignment.
 type Train struct {
-	TrainNumber  string `json:"trainNumber"`
-	CarriageLeft int    `json:"carriageLeft"`
+	TrainNumber   string `json:"trainNumber"`
+	Capacity      int    `json:"capacity"`
+	CarriageLeft  int    `json:"carriageLeft"`
+	VehicleNumber int    `json:"vehicleNumber"`
+	ArrivalDate   string `json:"arrivalDate"`
 }
 
 type Trains struct {
@@ -35,6 +43,31 @@ type TrainQueryResults struct {
 	Data Trains `json:"data"`
 }
 
+//trainScheduleNumber returns the schedule of the train,
+//trainNumber is the departure date (8 digits) followed by the scheduleNumber (4 digits)
+func trainScheduleNumber(trainNumber string) (int, error) {
+	if len(trainNumber) < 12 {
+		return 0, fmt.Errorf("trainNumber error: %s is shorter than 12", trainNumber)
+	}
+	scheduleNumber, err := strconv.Atoi(trainNumber[8:12])
+	if err != nil {
+		return 0, fmt.Errorf("trainNumber error: %v", err)
+	}
+	return scheduleNumber, nil
+}
+
+//trainDepartureDate returns the departure date of the train, which is the first 8 digits of trainNumber
+func trainDepartureDate(trainNumber string) (time.Time, error) {
+	if len(trainNumber) < 8 {
+		return time.Time{}, fmt.Errorf("trainNumber error: %s is shorter than 8", trainNumber)
+	}
+	departure, err := time.Parse("20060102", trainNumber[0:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("trainNumber error: %v", err)
+	}
+	return departure, nil
+}
+
 //TrainExists judges a schedule if exists or not
 func (s *SmartContract) TrainExists(ctx contractapi.TransactionContextInterface, trainNumber string) (bool, error) {
 	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{trainNumber})
@@ -49,114 +82,123 @@ func (s *SmartContract) TrainExists(ctx contractapi.TransactionContextInterface,
 	return trainJSON != nil, nil
 }
 
-//CreateTrain issues a new schedule to the world state with given details
-func (s *SmartContract) CreateTrain(ctx contractapi.TransactionContextInterface, trainNumber string, carriageLeft int) Result {
+//getTrain reads the train trainNumber from the world state, its CarriageLeft is not computed
+func getTrain(ctx contractapi.TransactionContextInterface, trainNumber string) (*Train, error) {
 	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{trainNumber})
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, err
 	}
-	exists, err := s.TrainExists(ctx, trainNumber)
+	trainJSON, err := ctx.GetStub().GetState(trainIndexKey)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return nil, fmt.Errorf("failed to read from world state %v", err)
 	}
-	if exists {
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the train %s already exists", trainNumber),
-		}
+	if trainJSON == nil {
+		return nil, fmt.Errorf("the train %s does not exist", trainNumber)
 	}
 
-	train := Train{
-		TrainNumber:  trainNumber,
-		CarriageLeft: carriageLeft,
+	var train Train
+	err = json.Unmarshal(trainJSON, &train)
+	if err != nil {
+		return nil, err
+	}
+	return &train, nil
+}
+
+//putTrain writes the train to the world state
+func putTrain(ctx contractapi.TransactionContextInterface, train *Train) error {
+	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{train.TrainNumber})
+	if err != nil {
+		return err
 	}
 	trainJSON, err := json.Marshal(train)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return err
 	}
-
 	err = ctx.GetStub().PutState(trainIndexKey, trainJSON)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
-	}
-
-	return Result{
-		Code: 200,
-		Msg:  "success",
+		return fmt.Errorf("failed to put to world state. %v", err)
 	}
+	return nil
 }
 
-//UpdateTrain updates an existing train in the world state with provided parameters
-func (s *SmartContract) UpdateTrain(ctx contractapi.TransactionContextInterface, trainNumber string, carriageNumber int) Result {
+//CreateTrain issues a new train with capacity carriages to the world state,
+//carriageTypes gives the type of every carriage in order and all carriages are general if it is empty.
+//The vehicle of the train's schedule is assigned to the train from the departure date to arrivalDate (formatted as 2006-01-02).
+//Everything is checked before anything is written, and a failure is returned as an error.
+func (s *SmartContract) CreateTrain(ctx contractapi.TransactionContextInterface, trainNumber, arrivalDate string, capacity int,
+	carriageTypes []string) (Result, error) {
 	trainIndexKey, err := ctx.GetStub().CreateCompositeKey(trainIndexName, []string{trainNumber})
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
-	trainJSON, err := ctx.GetStub().GetState(trainIndexKey)
+	exists, err := s.TrainExists(ctx, trainNumber)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
-	if trainJSON == nil {
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the train %s does not exist", trainNumber),
-		}
+	if exists {
+		return failure(fmt.Errorf("the train %s already exists", trainNumber))
 	}
 
-	var train Train
-	err = json.Unmarshal(trainJSON, &train)
+	if capacity <= 0 {
+		return failure(fmt.Errorf("the train's capacity %d must be positive", capacity))
+	}
+	if len(carriageTypes) != 0 && len(carriageTypes) != capacity {
+		return failure(fmt.Errorf("the train has %d carriages but %d carriage types", capacity, len(carriageTypes)))
+	}
+
+	//the vehicle of the train's schedule must be available and run no other train until the arrival date
+	scheduleNumber, err := trainScheduleNumber(trainNumber)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
-	if train.CarriageLeft < carriageNumber {
-		return Result{
-			Code: 402,
-			Msg: fmt.Sprintf("the train %s's carriageLeft is not enough: carriageLeft %d, carraigeNumber %d",
-				trainNumber, train.CarriageLeft, carriageNumber),
-		}
+	schedule, err := getSchedule(ctx, scheduleNumber)
+	if err != nil {
+		return failure(err)
 	}
-	//overwriting original carriageLeft
-	train.CarriageLeft -= carriageNumber
-	trainJSON, err = json.Marshal(train)
+	err = assignVehicle(ctx, schedule.VehicleNumber, trainNumber, arrivalDate)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
+	}
+
+	train := Train{
+		TrainNumber:   trainNumber,
+		Capacity:      capacity,
+		CarriageLeft:  capacity,
+		VehicleNumber: schedule.VehicleNumber,
+		ArrivalDate:   arrivalDate,
+	}
+	trainJSON, err := json.Marshal(train)
+	if err != nil {
+		return failure(err)
 	}
+
 	err = ctx.GetStub().PutState(trainIndexKey, trainJSON)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+		return failure(err)
+	}
+
+	for position := 1; position <= capacity; position++ {
+		carriage := Carriage{
+			TrainNumber:   trainNumber,
+			Position:      position,
+			CarriageType:  CarriageTypeGeneral,
+			Status:        CarriageStatusFree,
+			OrderId:       0,
+			ReservationId: "",
+		}
+		if len(carriageTypes) != 0 {
+			carriage.CarriageType = carriageTypes[position-1]
+		}
+		err = putCarriage(ctx, &carriage)
+		if err != nil {
+			return failure(err)
 		}
 	}
 
 	return Result{
 		Code: 200,
 		Msg:  "success",
-	}
+	}, nil
 }
 
 //QueryTrainBytrainnumber returns the train in the world state with given trainNumber
@@ -168,6 +210,7 @@ func (s *SmartContract) QueryTrainBytrainnumber(ctx contractapi.TransactionConte
 			Msg:  fmt.Sprintf("failed to read from world state: %v", err),
 			Data: Train{
 				TrainNumber:  "0",
+				Capacity:     0,
 				CarriageLeft: 0,
 			},
 		}
@@ -180,6 +223,7 @@ func (s *SmartContract) QueryTrainBytrainnumber(ctx contractapi.TransactionConte
 			Msg:  fmt.Sprintf("failed to read from world state: %v", err),
 			Data: Train{
 				TrainNumber:  "0",
+				Capacity:     0,
 				CarriageLeft: 0,
 			},
 		}
@@ -190,6 +234,7 @@ func (s *SmartContract) QueryTrainBytrainnumber(ctx contractapi.TransactionConte
 			Msg:  fmt.Sprintf("the train %s does not exist", trainNumber),
 			Data: Train{
 				TrainNumber:  "0",
+				Capacity:     0,
 				CarriageLeft: 0,
 			},
 		}
@@ -203,6 +248,19 @@ func (s *SmartContract) QueryTrainBytrainnumber(ctx contractapi.TransactionConte
 			Msg:  fmt.Sprintf("failed to read from world state: %v", err),
 			Data: Train{
 				TrainNumber:  "0",
+				Capacity:     0,
+				CarriageLeft: 0,
+			},
+		}
+	}
+	train.CarriageLeft, err = trainCarriageLeft(ctx, &train)
+	if err != nil {
+		return TrainQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Train{
+				TrainNumber:  "0",
+				Capacity:     0,
 				CarriageLeft: 0,
 			},
 		}
@@ -219,6 +277,7 @@ func (s *SmartContract) QueryAllTrains(ctx contractapi.TransactionContextInterfa
 	var emptytrains []Train
 	emptytrains = append(emptytrains, Train{
 		TrainNumber:  "0",
+		Capacity:     0,
 		CarriageLeft: 0,
 	})
 
@@ -252,6 +311,14 @@ func (s *SmartContract) QueryAllTrains(ctx contractapi.TransactionContextInterfa
 				Data: Trains{TrainsDate: emptytrains},
 			}
 		}
+		train.CarriageLeft, err = trainCarriageLeft(ctx, &train)
+		if err != nil {
+			return TrainQueryResults{
+				Code: 402,
+				Msg:  err.Error(),
+				Data: Trains{TrainsDate: emptytrains},
+			}
+		}
 		trains = append(trains, train)
 	}
 
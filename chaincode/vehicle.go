@@ -7,13 +7,18 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"strconv"
+	"strings"
 )
 
-//Vehicle describes details of a vehicle
+//Vehicle describes details of a vehicle, a vehicle built from wagons counts its wagons other than locomotives as carriages
 type Vehicle struct { //车辆
-	VehicleNumber int  `json:"vehicleNumber"`
-	CarriageNum   int  `json:"carriageNum"`
-	Using         bool `json:"using"`
+	VehicleNumber int      `json:"vehicleNumber"`
+	CarriageNum   int      `json:"carriageNum"`
+	Using         bool     `json:"using"`
+	Wagons        []string `json:"wagons,omitempty" metadata:",optional"` //UIC numbers of the wagons in order
+	//planned windows the vehicle is out of service and maintenance performed
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty" metadata:",optional"`
+	MaintenanceRecords []MaintenanceRecord `json:"maintenanceRecords,omitempty" metadata:",optional"`
 }
 
 type Vehicles struct {
@@ -48,6 +53,45 @@ func (s *SmartContract) VehicleExists(ctx contractapi.TransactionContextInterfac
 	return vehicleJSON != nil, nil
 }
 
+//getVehicle reads the vehicle vehicleNumber from the world state
+func getVehicle(ctx contractapi.TransactionContextInterface, vehicleNumber int) (*Vehicle, error) {
+	vehicleIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndexName, []string{strconv.Itoa(vehicleNumber)})
+	if err != nil {
+		return nil, err
+	}
+	vehicleJSON, err := ctx.GetStub().GetState(vehicleIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if vehicleJSON == nil {
+		return nil, fmt.Errorf("the vehicle %d does not exist", vehicleNumber)
+	}
+
+	var vehicle Vehicle
+	err = json.Unmarshal(vehicleJSON, &vehicle)
+	if err != nil {
+		return nil, err
+	}
+	return &vehicle, nil
+}
+
+//putVehicle writes the vehicle to the world state
+func putVehicle(ctx contractapi.TransactionContextInterface, vehicle *Vehicle) error {
+	vehicleIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndexName, []string{strconv.Itoa(vehicle.VehicleNumber)})
+	if err != nil {
+		return err
+	}
+	vehicleJSON, err := json.Marshal(vehicle)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(vehicleIndexKey, vehicleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
 //CreateVehicle issues a new vehicle to the world state with given details.
 func (s *SmartContract) CreateVehicle(ctx contractapi.TransactionContextInterface, vehicleNumber, carriageNum int) Result {
 	vehicleIndexKey, err := ctx.GetStub().CreateCompositeKey(vehicleIndexName, []string{strconv.Itoa(vehicleNumber)})
@@ -109,6 +153,21 @@ func (s *SmartContract) DeleteVehicle(ctx contractapi.TransactionContextInterfac
 		}
 	}
 
+	//wagons of the vehicle must be removed first, or they would refer to a vehicle that doesn't exist
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if len(vehicle.Wagons) > 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the vehicle %d has wagons %s, remove them by RemoveWagonFromVehicle first", vehicleNumber, strings.Join(vehicle.Wagons, " ")),
+		}
+	}
+
 	//if the vehicle is used by some schedules, the vehicle couldn't be delete.
 	vehicleResultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(vehiclescheduleIndexName, []string{strconv.Itoa(vehicleNumber)})
 	if err != nil {
@@ -0,0 +1,434 @@
+//@author: hdsfade
+//@date: 2021-02-24-10:15
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+var wagonIndexName = "wagon"
+
+//WagonTypeLocomotive is the type of locomotives, other wagons are carriages of the vehicle
+var WagonTypeLocomotive = "locomotive"
+
+//wagon states
+const (
+	WagonStatusInService   = "inservice"   //运营中
+	WagonStatusMaintenance = "maintenance" //维修中
+	WagonStatusWithdrawn   = "withdrawn"   //已退役
+)
+
+//Wagon describes a wagon or locomotive of the rolling stock
+type Wagon struct { //车皮
+	UICNumber     string `json:"uicNumber"` //12 digits with the check digit
+	WagonType     string `json:"wagonType"`
+	Tare          int    `json:"tare"`    //kg
+	Payload       int    `json:"payload"` //kg
+	Owner         string `json:"owner"`
+	Gauge         int    `json:"gauge"` //mm
+	Status        string `json:"status"`
+	VehicleNumber int    `json:"vehicleNumber"` //0 if the wagon is not in a vehicle
+}
+
+//WagonQueryResult structure used for handing result of query
+type WagonQueryResult struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data Wagon  `json:"data"`
+}
+
+//checkUICNumber judges a UIC wagon number if valid or not,
+//the 12th digit checks the first 11 digits weighted 2,1,2,1... from the left
+func checkUICNumber(uicNumber string) error {
+	if len(uicNumber) != 12 {
+		return fmt.Errorf("the UIC number %s doesn't have 12 digits", uicNumber)
+	}
+	sum := 0
+	for i := 0; i < 12; i++ {
+		if uicNumber[i] < '0' || uicNumber[i] > '9' {
+			return fmt.Errorf("the UIC number %s has non-digit characters", uicNumber)
+		}
+		if i == 11 {
+			break
+		}
+		product := int(uicNumber[i]-'0') * (2 - i%2)
+		sum += product/10 + product%10
+	}
+	checkDigit := (10 - sum%10) % 10
+	if int(uicNumber[11]-'0') != checkDigit {
+		return fmt.Errorf("the UIC number %s's check digit should be %d", uicNumber, checkDigit)
+	}
+	return nil
+}
+
+//WagonExists judges a wagon if exists or not
+func (s *SmartContract) WagonExists(ctx contractapi.TransactionContextInterface, uicNumber string) (bool, error) {
+	wagonIndexKey, err := ctx.GetStub().CreateCompositeKey(wagonIndexName, []string{uicNumber})
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+
+	wagonJSON, err := ctx.GetStub().GetState(wagonIndexKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state %v", err)
+	}
+	return wagonJSON != nil, nil
+}
+
+//getWagon reads the wagon uicNumber from the world state
+func getWagon(ctx contractapi.TransactionContextInterface, uicNumber string) (*Wagon, error) {
+	wagonIndexKey, err := ctx.GetStub().CreateCompositeKey(wagonIndexName, []string{uicNumber})
+	if err != nil {
+		return nil, err
+	}
+	wagonJSON, err := ctx.GetStub().GetState(wagonIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if wagonJSON == nil {
+		return nil, fmt.Errorf("the wagon %s does not exist", uicNumber)
+	}
+
+	var wagon Wagon
+	err = json.Unmarshal(wagonJSON, &wagon)
+	if err != nil {
+		return nil, err
+	}
+	return &wagon, nil
+}
+
+//putWagon writes the wagon to the world state
+func putWagon(ctx contractapi.TransactionContextInterface, wagon *Wagon) error {
+	wagonIndexKey, err := ctx.GetStub().CreateCompositeKey(wagonIndexName, []string{wagon.UICNumber})
+	if err != nil {
+		return err
+	}
+	wagonJSON, err := json.Marshal(wagon)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutState(wagonIndexKey, wagonJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+//vehicleWagons returns the wagons of the vehicle in order
+func vehicleWagons(ctx contractapi.TransactionContextInterface, vehicle *Vehicle) ([]*Wagon, error) {
+	var wagons []*Wagon
+	for _, uicNumber := range vehicle.Wagons {
+		wagon, err := getWagon(ctx, uicNumber)
+		if err != nil {
+			return nil, err
+		}
+		wagons = append(wagons, wagon)
+	}
+	return wagons, nil
+}
+
+//vehicleCarriageNum returns the carriage number of a vehicle built from wagons, which is the number of its wagons other than locomotives
+func vehicleCarriageNum(ctx contractapi.TransactionContextInterface, vehicle *Vehicle) (int, error) {
+	wagons, err := vehicleWagons(ctx, vehicle)
+	if err != nil {
+		return 0, err
+	}
+	carriageNum := 0
+	for _, wagon := range wagons {
+		if wagon.WagonType != WagonTypeLocomotive {
+			carriageNum++
+		}
+	}
+	return carriageNum, nil
+}
+
+//setVehicleWagons replaces the wagons of the vehicle and updates its carriage number. A vehicle built from wagons
+//has as many carriages as its wagons, a vehicle created with a carriage number keeps it until its wagons make up as many
+func setVehicleWagons(ctx contractapi.TransactionContextInterface, vehicle *Vehicle, wagons []string) error {
+	previous, err := vehicleCarriageNum(ctx, vehicle)
+	if err != nil {
+		return err
+	}
+	vehicle.Wagons = wagons
+	carriageNum, err := vehicleCarriageNum(ctx, vehicle)
+	if err != nil {
+		return err
+	}
+	if previous == vehicle.CarriageNum || carriageNum >= vehicle.CarriageNum {
+		vehicle.CarriageNum = carriageNum
+	}
+	return nil
+}
+
+//checkVehicleInService judges the vehicle if could be used by schedules or not,
+//the vehicle must be in use and all of its wagons must be in service
+func checkVehicleInService(ctx contractapi.TransactionContextInterface, vehicleNumber int) error {
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return err
+	}
+	if !vehicle.Using {
+		return fmt.Errorf("the vehicle %d is not in use", vehicleNumber)
+	}
+	wagons, err := vehicleWagons(ctx, vehicle)
+	if err != nil {
+		return err
+	}
+	for _, wagon := range wagons {
+		if wagon.Status != WagonStatusInService {
+			return fmt.Errorf("the wagon %s of the vehicle %d is %s", wagon.UICNumber, vehicleNumber, wagon.Status)
+		}
+	}
+	return nil
+}
+
+//CreateWagon issues a new wagon in service to the world state with given details
+func (s *SmartContract) CreateWagon(ctx contractapi.TransactionContextInterface, uicNumber, wagonType string, tare, payload int,
+	owner string, gauge int) Result {
+	err := checkUICNumber(uicNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if wagonType == "" || owner == "" {
+		return Result{
+			Code: 402,
+			Msg:  "the wagon's type and owner are required",
+		}
+	}
+	if tare <= 0 || payload < 0 || gauge <= 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon's tare %d and gauge %d must be positive and payload %d not negative", tare, gauge, payload),
+		}
+	}
+	exists, err := s.WagonExists(ctx, uicNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if exists {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon %s already exists", uicNumber),
+		}
+	}
+
+	wagon := Wagon{
+		UICNumber:     uicNumber,
+		WagonType:     wagonType,
+		Tare:          tare,
+		Payload:       payload,
+		Owner:         owner,
+		Gauge:         gauge,
+		Status:        WagonStatusInService,
+		VehicleNumber: 0,
+	}
+	err = putWagon(ctx, &wagon)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//SetWagonStatus sets the status of the wagon to inservice, maintenance or withdrawn,
+//a withdrawn wagon must be removed from its vehicle first
+func (s *SmartContract) SetWagonStatus(ctx contractapi.TransactionContextInterface, uicNumber, status string) Result {
+	if status != WagonStatusInService && status != WagonStatusMaintenance && status != WagonStatusWithdrawn {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon status %s is not one of %s, %s, %s", status, WagonStatusInService, WagonStatusMaintenance, WagonStatusWithdrawn),
+		}
+	}
+	wagon, err := getWagon(ctx, uicNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if status == WagonStatusWithdrawn && wagon.VehicleNumber != 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon %s is in the vehicle %d", uicNumber, wagon.VehicleNumber),
+		}
+	}
+
+	wagon.Status = status
+	err = putWagon(ctx, wagon)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//AddWagonToVehicle inserts the wagon into the vehicle at position (from 0, appended if position is out of the vehicle),
+//all wagons of a vehicle must have the same gauge. A vehicle created with a carriage number keeps it until its wagons make up as many carriages
+func (s *SmartContract) AddWagonToVehicle(ctx contractapi.TransactionContextInterface, vehicleNumber int, uicNumber string, position int) Result {
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	wagon, err := getWagon(ctx, uicNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if wagon.VehicleNumber != 0 {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon %s is in the vehicle %d", uicNumber, wagon.VehicleNumber),
+		}
+	}
+	if wagon.Status == WagonStatusWithdrawn {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon %s is withdrawn", uicNumber),
+		}
+	}
+	if len(vehicle.Wagons) > 0 {
+		first, err := getWagon(ctx, vehicle.Wagons[0])
+		if err != nil {
+			return Result{
+				Code: 402,
+				Msg:  err.Error(),
+			}
+		}
+		if first.Gauge != wagon.Gauge {
+			return Result{
+				Code: 402,
+				Msg:  fmt.Sprintf("the wagon %s's gauge %d differs from the vehicle %d's gauge %d", uicNumber, wagon.Gauge, vehicleNumber, first.Gauge),
+			}
+		}
+	}
+
+	if position < 0 || position > len(vehicle.Wagons) {
+		position = len(vehicle.Wagons)
+	}
+	wagons := append(append([]string{}, vehicle.Wagons[:position]...), uicNumber)
+	err = setVehicleWagons(ctx, vehicle, append(wagons, vehicle.Wagons[position:]...))
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	wagon.VehicleNumber = vehicleNumber
+
+	err = putWagon(ctx, wagon)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = putVehicle(ctx, vehicle)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//RemoveWagonFromVehicle removes the wagon from the vehicle, e.g. when wagons are swapped at a gauge-change station
+func (s *SmartContract) RemoveWagonFromVehicle(ctx contractapi.TransactionContextInterface, vehicleNumber int, uicNumber string) Result {
+	vehicle, err := getVehicle(ctx, vehicleNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	wagon, err := getWagon(ctx, uicNumber)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	if wagon.VehicleNumber != vehicleNumber {
+		return Result{
+			Code: 402,
+			Msg:  fmt.Sprintf("the wagon %s is not in the vehicle %d", uicNumber, vehicleNumber),
+		}
+	}
+
+	wagons := []string{}
+	for _, vehicleWagon := range vehicle.Wagons {
+		if vehicleWagon != uicNumber {
+			wagons = append(wagons, vehicleWagon)
+		}
+	}
+	err = setVehicleWagons(ctx, vehicle, wagons)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	wagon.VehicleNumber = 0
+
+	err = putWagon(ctx, wagon)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	err = putVehicle(ctx, vehicle)
+	if err != nil {
+		return Result{
+			Code: 402,
+			Msg:  err.Error(),
+		}
+	}
+	return Result{
+		Code: 200,
+		Msg:  "success",
+	}
+}
+
+//QueryWagonByuicnumber returns the wagon in the world state with given uicNumber
+func (s *SmartContract) QueryWagonByuicnumber(ctx contractapi.TransactionContextInterface, uicNumber string) WagonQueryResult {
+	wagon, err := getWagon(ctx, uicNumber)
+	if err != nil {
+		return WagonQueryResult{
+			Code: 402,
+			Msg:  err.Error(),
+			Data: Wagon{},
+		}
+	}
+	return WagonQueryResult{
+		Code: 200,
+		Msg:  "success",
+		Data: *wagon,
+	}
+}
@@ -4,6 +4,7 @@ package chaincode
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"strconv"
@@ -42,6 +43,36 @@ func (s *SmartContract) WayBillExists(ctx contractapi.TransactionContextInterfac
 	return trainJSON != nil, nil
 }
 
+//getWayBill reads the waybill with given trainNumber from the world state
+func getWayBill(ctx contractapi.TransactionContextInterface, trainNumber string) (*WayBill, error) {
+	waybillIndexKey, err := ctx.GetStub().CreateCompositeKey(waybillIndexName, []string{trainNumber})
+	if err != nil {
+		return nil, err
+	}
+	wayBillJSON, err := ctx.GetStub().GetState(waybillIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state %v", err)
+	}
+	if wayBillJSON == nil {
+		return nil, fmt.Errorf("the waybill %s does not exist", trainNumber)
+	}
+
+	var waybill WayBill
+	err = json.Unmarshal(wayBillJSON, &waybill)
+	if err != nil {
+		return nil, err
+	}
+	return &waybill, nil
+}
+
+//currentStation returns the station the train is at, empty if its location is unknown
+func (waybill *WayBill) currentStation() string {
+	if waybill.Location >= 0 && waybill.Location < len(waybill.WayStation) {
+		return waybill.WayStation[waybill.Location]
+	}
+	return ""
+}
+
 //type WayBillExistData struct {
 //	ISWayBillExist bool `json:"isWayBillExist"`
 //}
@@ -65,125 +96,80 @@ func (s *SmartContract) HasWayBill(ctx contractapi.TransactionContextInterface,
 	}
 }
 
-////CreateWayBill issues a new line to the world state with given details.
-func (s *SmartContract) CreateWayBill(ctx contractapi.TransactionContextInterface, trainNumber string) Result {
-	createCargoResult := s.CreateCargo(ctx, trainNumber)
-	if createCargoResult.Code != 200 {
-		return createCargoResult
-	}
+//CreateWayBill issues a new waybill and the cargo of the train to the world state.
+//Everything is checked before anything is written, and a failure is returned as an error
+//so that Fabric discards all writes of the transaction.
+func (s *SmartContract) CreateWayBill(ctx contractapi.TransactionContextInterface, trainNumber string) (Result, error) {
 	waybillIndexKey, err := ctx.GetStub().CreateCompositeKey(waybillIndexName, []string{trainNumber})
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 
 	exists, err := s.WayBillExists(ctx, trainNumber)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 	if exists {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the waybill %s already exists", trainNumber),
-		}
+		return failure(fmt.Errorf("the waybill %s already exists", trainNumber))
+	}
+
+	exists, err = s.CargoExists(ctx, trainNumber)
+	if err != nil {
+		return failure(err)
+	}
+	if exists {
+		return failure(fmt.Errorf("the cargo %s already exists", trainNumber))
 	}
 
 	exists, err = s.TrainExists(ctx, trainNumber)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 	if !exists {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the train %s does not exist", trainNumber),
-		}
+		return failure(fmt.Errorf("the train %s does not exist", trainNumber))
 	}
 
-	scheduleNumber, err := strconv.Atoi(trainNumber[8:12])
+	scheduleNumber, err := trainScheduleNumber(trainNumber)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("trainNumber error: %v", err),
-		}
+		return failure(err)
 	}
 	scheduleIndexKey, err := ctx.GetStub().CreateCompositeKey(scheduleIndexName, []string{strconv.Itoa(scheduleNumber)})
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("failed to read schedule %d from world state: %v", scheduleNumber, err),
-		}
+		return failure(fmt.Errorf("failed to read schedule %d from world state: %v", scheduleNumber, err))
 	}
 	scheduleJSON, err := ctx.GetStub().GetState(scheduleIndexKey)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("failed to read schedule %d from world state: %v", scheduleNumber, err),
-		}
+		return failure(fmt.Errorf("failed to read schedule %d from world state: %v", scheduleNumber, err))
 	}
 	if scheduleJSON == nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the schedule %d does not exist", scheduleNumber),
-		}
+		return failure(fmt.Errorf("the schedule %d does not exist", scheduleNumber))
 	}
 	var schedule Schedule
 	err = json.Unmarshal(scheduleJSON, &schedule)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 
 	lineIndexKey, err := ctx.GetStub().CreateCompositeKey(lineIndexName, []string{strconv.Itoa(schedule.LineNumber)})
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("failed to read schedule %d's line %d from world state: %v", scheduleNumber, schedule.LineNumber, err),
-		}
+		return failure(fmt.Errorf("failed to read schedule %d's line %d from world state: %v", scheduleNumber, schedule.LineNumber, err))
 	}
 	lineJSON, err := ctx.GetStub().GetState(lineIndexKey)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("failed to read schedule %d's line %d from world state: %v", scheduleNumber, schedule.LineNumber, err),
-		}
+		return failure(fmt.Errorf("failed to read schedule %d's line %d from world state: %v", scheduleNumber, schedule.LineNumber, err))
 	}
 	if lineJSON == nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the schedule %d's line %d does not exist", scheduleNumber, schedule.LineNumber),
-		}
+		return failure(fmt.Errorf("the schedule %d's line %d does not exist", scheduleNumber, schedule.LineNumber))
 	}
 	var line Line
 	err = json.Unmarshal(lineJSON, &line)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
+	}
+
+	createCargoResult := s.CreateCargo(ctx, trainNumber)
+	if createCargoResult.Code != 200 {
+		return failure(errors.New(createCargoResult.Msg))
 	}
 
 	wayBill := WayBill{
@@ -197,58 +183,65 @@ func (s *SmartContract) CreateWayBill(ctx contractapi.TransactionContextInterfac
 	}
 	wayBillJSON, err := json.Marshal(wayBill)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 
 	err = ctx.GetStub().PutState(waybillIndexKey, wayBillJSON)
 	if err != nil {
-		s.DeleteCargo(ctx, trainNumber)
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 
 	return Result{
 		Code: 200,
 		Msg:  "success",
-	}
+	}, nil
 }
 
-//UpdateWayBill updates an existing waybill in the world state with provided parameters
+//UpdateWayBill updates an existing waybill in the world state with provided parameters,
+//a failure is returned as an error so that the cargo state isn't changed without the waybill
 func (s *SmartContract) UpdateWayBill(ctx contractapi.TransactionContextInterface, trainNumber, arrivalTime, leaveTime string, location int,
-	stationTrainState bool, checkDescription string) Result {
+	stationTrainState bool, checkDescription string) (Result, error) {
 	waybillIndexKey, err := ctx.GetStub().CreateCompositeKey(waybillIndexName, []string{trainNumber})
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 	wayBillJSON, err := ctx.GetStub().GetState(waybillIndexKey)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 	if wayBillJSON == nil {
-		return Result{
-			Code: 402,
-			Msg:  fmt.Sprintf("the waybill %s does not exist", trainNumber),
-		}
+		return failure(fmt.Errorf("the waybill %s does not exist", trainNumber))
 	}
 
 	var waybill WayBill
 	err = json.Unmarshal(wayBillJSON, &waybill)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
+		return failure(err)
+	}
+
+	//the train couldn't leave the station while its orders are on hold
+	if arrivalTime == "" {
+		hold, err := getActiveHold(ctx, trainNumber)
+		if err != nil {
+			return failure(err)
+		}
+		if hold != nil {
+			return failure(fmt.Errorf("the train %s is on hold at station %s, orders %v must be released or offloaded before departure",
+				trainNumber, hold.StationName, hold.OrderIds))
+		}
+	}
+
+	//the cargo is in transit once the train leaves its starting station,
+	//and delivered once the train arrives at its terminal station
+	if arrivalTime == "" && len(waybill.LeaveTime) == 0 {
+		err = setCargoState(ctx, trainNumber, CargoStateSealed, CargoStateInTransit)
+		if err != nil {
+			return failure(fmt.Errorf("the train %s couldn't leave its starting station: %v", trainNumber, err))
+		}
+	} else if leaveTime == "" && location == len(waybill.WayStation)-1 {
+		err = setCargoState(ctx, trainNumber, CargoStateInTransit, CargoStateDelivered)
+		if err != nil {
+			return failure(err)
 		}
 	}
 
@@ -263,23 +256,17 @@ func (s *SmartContract) UpdateWayBill(ctx contractapi.TransactionContextInterfac
 	waybill.CheckDescription = checkDescription
 	wayBillJSON, err = json.Marshal(waybill)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 	err = ctx.GetStub().PutState(waybillIndexKey, wayBillJSON)
 	if err != nil {
-		return Result{
-			Code: 402,
-			Msg:  err.Error(),
-		}
+		return failure(err)
 	}
 
 	return Result{
 		Code: 200,
 		Msg:  "success",
-	}
+	}, nil
 }
 
 //QueryWayBillBytrainnumber returns the waybill in the world state with given trainnumber